@@ -0,0 +1,292 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var promptKeys = []string{"prompt", "default", "choices", "validate"}
+
+type varPrompt struct {
+	text     string
+	value    interface{}
+	choices  []interface{}
+	validate string
+}
+
+type answerEntry struct {
+	Name   string      `json:"name"`
+	Value  interface{} `json:"value,omitempty"`
+	Prompt string      `json:"prompt,omitempty"`
+}
+
+func parsePrompt(value interface{}) (*varPrompt, bool) {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	text, ok := fields["prompt"].(string)
+	if !ok {
+		return nil, false
+	}
+
+	for key := range fields {
+		if !containsString(promptKeys, key) {
+			return nil, false
+		}
+	}
+
+	p := &varPrompt{text: text, value: fields["default"]}
+	p.choices, _ = fields["choices"].([]interface{})
+	p.validate, _ = fields["validate"].(string)
+
+	return p, true
+}
+
+func (p *varPrompt) check(value interface{}) error {
+	answer := formatVar(value)
+
+	if len(p.choices) > 0 {
+		var choices []string
+		for _, choice := range p.choices {
+			choices = append(choices, formatVar(choice))
+		}
+		if !containsString(choices, answer) {
+			return fmt.Errorf("answer must be one of: %s", strings.Join(choices, ", "))
+		}
+	}
+
+	if len(p.validate) > 0 {
+		exp, err := regexp.Compile("^(?:" + p.validate + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid validation expression %q: %w", p.validate, err)
+		}
+		if !exp.MatchString(answer) {
+			return fmt.Errorf("answer does not match %q", p.validate)
+		}
+	}
+
+	return nil
+}
+
+func (p *varPrompt) ask() (interface{}, error) {
+	var choices []string
+	for _, choice := range p.choices {
+		choices = append(choices, formatVar(choice))
+	}
+
+	for {
+		fmt.Print(p.text)
+		if len(choices) > 0 {
+			fmt.Printf(" (%s)", strings.Join(choices, ", "))
+		}
+		if p.value != nil {
+			fmt.Printf(" [%s]", formatVar(p.value))
+		}
+		fmt.Print(": ")
+
+		line, err := readLine()
+		if err != nil {
+			fmt.Println()
+			return nil, err
+		}
+
+		var value interface{}
+		if line = strings.TrimSpace(line); len(line) == 0 && p.value != nil {
+			value = p.value
+		} else {
+			value = parseValue(line)
+		}
+
+		if err := p.check(value); err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		return value, nil
+	}
+}
+
+func (conf *config) answer(key, name string, p *varPrompt) (interface{}, error) {
+	if value, ok := lookupVar(conf.overrides, name); ok {
+		return value, nil
+	}
+
+	if value, ok := conf.state.answer(key); ok {
+		if err := p.check(value); err == nil {
+			return value, nil
+		} else if conf.flags&flagVerbose != 0 {
+			log.Printf("discarding stored answer for variable %s: %s", key, err)
+		}
+	}
+
+	readOnly := conf.flags&(flagDryRun|flagNoPrompt) != 0
+	if readOnly || !isInteractive() {
+		if p.value != nil {
+			if conf.flags&flagVerbose != 0 {
+				log.Printf("using default answer for variable %s", key)
+			}
+			return p.value, nil
+		}
+		if readOnly {
+			return nil, fmt.Errorf("no stored answer for variable %s (set it with -var or answer it with apply)", key)
+		}
+		return nil, fmt.Errorf("no stored answer for variable %s (set it with -var or run interactively)", key)
+	}
+
+	value, err := p.ask()
+	if err != nil {
+		return nil, fmt.Errorf("no answer for variable %s: %w", key, err)
+	}
+
+	conf.state.setAnswer(key, value)
+	if err := conf.state.save(); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func (conf *config) resolvePrompts(vars map[string]interface{}, scope, prefix string) error {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if p, ok := parsePrompt(vars[name]); ok {
+			value, err := conf.answer(scope+prefix+name, prefix+name, p)
+			if err != nil {
+				return err
+			}
+			vars[name] = value
+		} else if fields, ok := vars[name].(map[string]interface{}); ok {
+			if err := conf.resolvePrompts(fields, scope, prefix+name+"."); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func findPrompts(vars map[string]interface{}, prefix string, prompts map[string]string) {
+	for name, value := range vars {
+		if p, ok := parsePrompt(value); ok {
+			prompts[prefix+name] = p.text
+		} else if fields, ok := value.(map[string]interface{}); ok {
+			findPrompts(fields, prefix+name+".", prompts)
+		}
+	}
+}
+
+func newAnswerEntries(names []string, conf *config) []answerEntry {
+	prompts := make(map[string]string)
+	findPrompts(conf.Vars, "", prompts)
+	for tn, t := range conf.Tasks {
+		findPrompts(t.Vars, tn+"/", prompts)
+	}
+
+	answers := conf.state.answers()
+	for name := range answers {
+		if _, ok := prompts[name]; !ok {
+			prompts[name] = ""
+		}
+	}
+
+	entries := []answerEntry{}
+	for name, text := range prompts {
+		if len(names) == 0 || containsString(names, name) {
+			entries = append(entries, answerEntry{Name: name, Value: answers[name], Prompt: text})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries
+}
+
+func printAnswers(format string, names []string, conf *config) error {
+	entries := newAnswerEntries(names, conf)
+
+	switch format {
+	case "", "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "VAR\tANSWER\tPROMPT\n")
+		for _, entry := range entries {
+			answer := "-"
+			if entry.Value != nil {
+				answer = formatVar(entry.Value)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Name, answer, entry.Prompt)
+		}
+		return w.Flush()
+	case "json":
+		bytes, err := json.MarshalIndent(entries, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(bytes))
+		return nil
+	default:
+		return fmt.Errorf("unsupported vars format: %s", format)
+	}
+}
+
+func resetAnswers(names []string, conf *config) error {
+	answers := conf.state.answers()
+	if len(names) == 0 {
+		for name := range answers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		if _, ok := answers[name]; !ok {
+			return fmt.Errorf("no stored answer for variable: %s", name)
+		}
+	}
+
+	for _, name := range names {
+		if conf.flags&flagVerbose != 0 {
+			log.Printf("forgetting answer for variable: %s", name)
+		}
+		conf.state.setAnswer(name, nil)
+	}
+
+	return conf.state.save()
+}
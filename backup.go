@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+func backupPath(loc, hash string, conf *config) error {
+	rel, err := filepath.Rel(conf.dstDir, loc)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = loc
+	}
+
+	dir, err := conf.state.backups()
+	if err != nil {
+		return err
+	}
+
+	backup := filepath.Join(dir, rel)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+		backup = fmt.Sprintf("%s.%d", filepath.Join(dir, rel), i)
+	}
+
+	if conf.flags&flagVerbose != 0 {
+		log.Printf("backing up %s to %s", loc, backup)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(backup), 0700); err != nil {
+		return err
+	}
+	if err := movePath(loc, backup); err != nil {
+		return err
+	}
+
+	conf.state.record(conf.task, recordBackup, loc, backup, hash)
+	return nil
+}
+
+func copyPath(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case info.IsDir():
+		if err := os.Mkdir(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+
+		infos, err := ioutil.ReadDir(src)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range infos {
+			if err := copyPath(filepath.Join(src, child.Name()), filepath.Join(dst, child.Name())); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+
+		return out.Close()
+	}
+}
+
+func movePath(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyPath(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+
+	return os.RemoveAll(src)
+}
+
+func restoreBackup(r stateRecord, conf *config) error {
+	if _, err := os.Lstat(r.Source); err != nil {
+		return fmt.Errorf("backup of %s not found at %s", r.Path, r.Source)
+	}
+
+	if info, _ := os.Lstat(r.Path); info != nil {
+		if info.Mode()&os.ModeSymlink == 0 && conf.state.rendered(r.Path, hashFile(r.Path)) {
+			if conf.flags&flagDryRun != 0 {
+				plan("remove template: %s", r.Path)
+			} else {
+				if conf.flags&flagVerbose != 0 {
+					log.Printf("removing template: %s", r.Path)
+				}
+				if err := try(func() error { return os.Remove(r.Path) }, conf); err != nil {
+					return err
+				}
+			}
+		} else {
+			flags := conf.flags
+			conf.flags |= flagBackup
+			cleaned, err := cleanPath(r.Path, conf)
+			conf.flags = flags
+			if err != nil || !cleaned {
+				return err
+			}
+		}
+	}
+
+	if conf.flags&flagDryRun != 0 {
+		plan("restore %s from %s", r.Path, r.Source)
+		return nil
+	}
+
+	if conf.flags&flagVerbose != 0 {
+		log.Printf("restoring %s from %s", r.Path, r.Source)
+	}
+
+	if err := try(func() error { return os.MkdirAll(filepath.Dir(r.Path), 0755) }, conf); err != nil {
+		return err
+	}
+	if err := try(func() error { return movePath(r.Source, r.Path) }, conf); err != nil {
+		return err
+	}
+
+	conf.state.forgetRecord(r)
+	for _, kind := range []string{recordLink, recordTemplate} {
+		conf.state.forget(kind, r.Path)
+	}
+
+	return nil
+}
+
+func restoreBackups(conf *config) error {
+	latest := make(map[string]stateRecord)
+	for _, r := range conf.state.records() {
+		if prev, ok := latest[r.Path]; r.Kind == recordBackup && (!ok || r.Time.After(prev.Time)) {
+			latest[r.Path] = r
+		}
+	}
+
+	var backups []stateRecord
+	for _, r := range latest {
+		backups = append(backups, r)
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Path < backups[j].Path })
+
+	for _, r := range backups {
+		if err := restoreBackup(r, conf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
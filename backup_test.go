@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newStateConfig(t *testing.T, flags int) *config {
+	t.Helper()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	s, err := loadState(filepath.Join(t.TempDir(), "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &config{
+		dstDir: t.TempDir(),
+		flags:  flags,
+		task:   "default",
+		state:  s,
+		policy: policy{onError: errorAbort},
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func backupRecords(conf *config) []stateRecord {
+	var records []stateRecord
+	for _, r := range conf.state.records() {
+		if r.Kind == recordBackup {
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	conf := newStateConfig(t, flagClobber|flagBackup)
+	path := filepath.Join(conf.dstDir, "a")
+
+	for _, data := range []string{"v1", "v2"} {
+		writeTestFile(t, path, data)
+		if cleaned, err := cleanPath(path, conf); err != nil || !cleaned {
+			t.Fatalf("backing up %s: cleaned %t, %v", data, cleaned, err)
+		}
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Fatalf("backing up %s: path still exists", data)
+		}
+	}
+
+	records := backupRecords(conf)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 backup records, got %d", len(records))
+	}
+	if records[0].Source == records[1].Source {
+		t.Fatalf("both backups were written to %s", records[0].Source)
+	}
+	if data := readTestFile(t, records[0].Source); data != "v1" {
+		t.Errorf("expected first backup to contain v1, got %q", data)
+	}
+	if data := readTestFile(t, records[1].Source); data != "v2" {
+		t.Errorf("expected second backup to contain v2, got %q", data)
+	}
+
+	conf.flags = flagClobber
+	for _, data := range []string{"v2", "v1"} {
+		if err := restoreBackups(conf); err != nil {
+			t.Fatalf("restoring %s: %v", data, err)
+		}
+		if restored := readTestFile(t, path); restored != data {
+			t.Errorf("expected restore to put back %q, got %q", data, restored)
+		}
+	}
+}
+
+func TestBackupSeparateRuns(t *testing.T) {
+	conf := newStateConfig(t, flagClobber|flagBackup)
+	path := filepath.Join(conf.dstDir, "a")
+
+	var dirs []string
+	for _, data := range []string{"v1", "v2"} {
+		conf.state.backupDir = ""
+		writeTestFile(t, path, data)
+		if _, err := cleanPath(path, conf); err != nil {
+			t.Fatal(err)
+		}
+
+		dirs = append(dirs, conf.state.backupDir)
+	}
+
+	if dirs[0] == dirs[1] {
+		t.Fatalf("both runs used backup directory %s", dirs[0])
+	}
+
+	records := backupRecords(conf)
+	if len(records) != 2 || readTestFile(t, records[0].Source) != "v1" || readTestFile(t, records[1].Source) != "v2" {
+		t.Fatalf("unexpected backup records: %+v", records)
+	}
+}
+
+func TestBackupOutsideDestination(t *testing.T) {
+	conf := newStateConfig(t, flagClobber|flagBackup)
+	path := filepath.Join(filepath.Dir(conf.dstDir), filepath.Base(conf.dstDir)+"..foo")
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	writeTestFile(t, filepath.Join(path, "file"), "data")
+	if _, err := cleanPath(path, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	records := backupRecords(conf)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 backup record, got %d", len(records))
+	}
+	if data := readTestFile(t, filepath.Join(records[0].Source, "file")); data != "data" {
+		t.Errorf("expected backed up directory to contain data, got %q", data)
+	}
+	if rel, _ := filepath.Rel(conf.state.backupDir, records[0].Source); rel == ".." || filepath.IsAbs(rel) {
+		t.Errorf("backup %s is outside of %s", records[0].Source, conf.state.backupDir)
+	}
+}
+
+func TestPruneLink(t *testing.T) {
+	conf := newStateConfig(t, flagClobber)
+	src := filepath.Join(t.TempDir(), "src")
+	other := filepath.Join(t.TempDir(), "other")
+	writeTestFile(t, src, "src")
+	writeTestFile(t, other, "other")
+
+	tests := []struct {
+		name   string
+		target string
+		file   bool
+		remain bool
+	}{
+		{name: "linked", target: src},
+		{name: "repointed", target: other, remain: true},
+		{name: "replaced", file: true, remain: true},
+		{name: "missing"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(conf.dstDir, test.name)
+		switch {
+		case test.file:
+			writeTestFile(t, path, "user")
+		case len(test.target) > 0:
+			if err := os.Symlink(test.target, path); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		conf.state.record(conf.task, recordLink, path, src, "")
+		r := conf.state.records()[len(conf.state.records())-1]
+		if err := pruneLink(r, conf); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if _, err := os.Lstat(path); (err == nil) != test.remain {
+			t.Errorf("%s: expected path to remain %t, got error %v", test.name, test.remain, err)
+		}
+		if test.file && readTestFile(t, path) != "user" {
+			t.Errorf("%s: file was modified", test.name)
+		}
+		for _, r := range conf.state.records() {
+			if r.Kind == recordLink && r.Path == path {
+				t.Errorf("%s: link record was not forgotten", test.name)
+			}
+		}
+	}
+}
@@ -32,9 +32,28 @@ import (
 )
 
 type macro struct {
-	Deps   []string
-	Prefix []string
-	Suffix []string
+	Description string   `json:"description,omitempty" yaml:"description,omitempty" toml:",omitempty"`
+	Hidden      bool     `json:"hidden,omitempty" yaml:"hidden,omitempty" toml:",omitempty"`
+	Deps        []string `json:"deps,omitempty" yaml:"deps,omitempty" toml:",omitempty"`
+	Prefix      []string `json:"prefix,omitempty" yaml:"prefix,omitempty" toml:",omitempty"`
+	Suffix      []string `json:"suffix,omitempty" yaml:"suffix,omitempty" toml:",omitempty"`
+}
+
+func (m macro) append(other macro) macro {
+	if len(other.Description) > 0 {
+		m.Description = other.Description
+	}
+	if len(other.Prefix) > 0 {
+		m.Prefix = other.Prefix
+	}
+	if len(other.Suffix) > 0 {
+		m.Suffix = other.Suffix
+	}
+
+	m.Hidden = m.Hidden || other.Hidden
+	m.Deps = append(m.Deps, other.Deps...)
+
+	return m
 }
 
 func findCmdMacro(macroName string, conf *config) (*macro, string) {
@@ -50,37 +69,38 @@ func findCmdMacro(macroName string, conf *config) (*macro, string) {
 	return nil, ""
 }
 
-func findCmdDeps(params []string, conf *config) []string {
-	if len(params) == 0 {
-		return nil
-	}
-
-	if m, _ := findCmdMacro(params[0], conf); m != nil {
-		return m.Deps
-	}
-
-	return nil
-}
-
 func processCmdMacro(macroName string, args []string, interact bool, conf *config) error {
 	m, mn := findCmdMacro(macroName, conf)
 	if m == nil {
 		return fmt.Errorf("macro or variant not found: %s", macroName)
 	}
 
-	margs := appendExpEnv(nil, m.Prefix)
-	margs = appendExpEnv(margs, args)
-	margs = appendExpEnv(margs, m.Suffix)
+	margs, err := appendExpanded(nil, m.Prefix, conf)
+	if err != nil {
+		return err
+	}
+	margs = append(margs, args...)
+	if margs, err = appendExpanded(margs, m.Suffix, conf); err != nil {
+		return err
+	}
 
 	if conf.flags&flagVerbose != 0 {
 		log.Printf("expanding macro: %s", mn)
 	}
 
-	return processCmd(margs, interact, conf)
+	return runCmd(margs, interact, conf)
 }
 
 func processCmd(params []string, interact bool, conf *config) error {
-	args := appendExpEnv(nil, params)
+	args, err := appendExpanded(nil, params, conf)
+	if err != nil {
+		return err
+	}
+
+	return runCmd(args, interact, conf)
+}
+
+func runCmd(args []string, interact bool, conf *config) error {
 	if len(args) == 0 {
 		return fmt.Errorf("invalid command statement")
 	}
@@ -99,6 +119,11 @@ func processCmd(params []string, interact bool, conf *config) error {
 		log.Printf("executing command: %s %s", cmdName, strings.Join(cmdArgs, " "))
 	}
 
+	if conf.flags&flagDryRun != 0 {
+		plan("execute command: %s", strings.Join(args, " "))
+		return nil
+	}
+
 	exec := func() error {
 		cmd := exec.Command(cmdName, cmdArgs...)
 		cmd.Dir = conf.dstDir
@@ -112,14 +137,13 @@ func processCmd(params []string, interact bool, conf *config) error {
 	}
 
 	if interact {
-		return try(exec)
+		return try(exec, conf)
 	}
 
 	return exec()
 }
 
-func processCmdWithReturn(params []string, conf *config) (string, error) {
-	args := appendExpEnv(nil, params)
+func processCmdWithReturn(args []string, conf *config) (string, error) {
 	if len(args) == 0 {
 		return "", fmt.Errorf("invalid command statement")
 	}
@@ -0,0 +1,424 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var completionValues = map[string]string{
+	"answer":   "prompt yes no",
+	"envscope": "global task",
+	"format":   "text json toml yaml dot mermaid",
+	"merge":    "error override append",
+	"onerror":  "prompt abort skip retry:",
+}
+
+func isBoolOption(name string) bool {
+	f := flag.Lookup(name)
+	if f == nil {
+		return false
+	}
+
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+func completionOptions(names []string) []string {
+	var options []string
+	for _, name := range names {
+		options = append(options, "-"+name)
+	}
+
+	return options
+}
+
+func globalOptions() []string {
+	var names []string
+	flag.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	return names
+}
+
+func commandNames() []string {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+
+	return names
+}
+
+func writeBashCompletion(w io.Writer) {
+	names := strings.Join(commandNames(), " ")
+
+	fmt.Fprintf(w, "# bash completion for homemaker\n\n")
+	fmt.Fprintf(w, "_homemaker() {\n")
+	fmt.Fprintf(w, "    local cur prev cmd opts i\n")
+	fmt.Fprintf(w, "    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(w, "    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	fmt.Fprintf(w, "    if [[ \"$cur\" == \"=\" ]]; then\n")
+	fmt.Fprintf(w, "        cur=\"\"\n")
+	fmt.Fprintf(w, "    elif [[ \"$prev\" == \"=\" ]]; then\n")
+	fmt.Fprintf(w, "        prev=\"${COMP_WORDS[COMP_CWORD-2]}\"\n")
+	fmt.Fprintf(w, "    fi\n\n")
+	fmt.Fprintf(w, "    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(w, "        case \"${COMP_WORDS[i]}\" in\n")
+	fmt.Fprintf(w, "            %s)\n", strings.Join(commandNames(), "|"))
+	fmt.Fprintf(w, "                cmd=\"${COMP_WORDS[i]}\"\n")
+	fmt.Fprintf(w, "                break\n")
+	fmt.Fprintf(w, "                ;;\n")
+	fmt.Fprintf(w, "        esac\n")
+	fmt.Fprintf(w, "    done\n\n")
+	fmt.Fprintf(w, "    case \"${prev#-}\" in\n")
+	fmt.Fprintf(w, "        -task|task|-skip|skip)\n")
+	fmt.Fprintf(w, "            COMPREPLY=($(compgen -W \"$(homemaker __complete tasks \"${COMP_WORDS[@]:1:COMP_CWORD-1}\" 2>/dev/null)\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        -tags|tags|-skiptags|skiptags)\n")
+	fmt.Fprintf(w, "            COMPREPLY=($(compgen -W \"$(homemaker __complete tags \"${COMP_WORDS[@]:1:COMP_CWORD-1}\" 2>/dev/null)\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        -variant|variant)\n")
+	fmt.Fprintf(w, "            COMPREPLY=($(compgen -W \"$(homemaker __complete variants \"${COMP_WORDS[@]:1:COMP_CWORD-1}\" 2>/dev/null)\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	for _, name := range sortedKeys(completionValues) {
+		fmt.Fprintf(w, "        -%s|%s)\n", name, name)
+		fmt.Fprintf(w, "            COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", completionValues[name])
+		fmt.Fprintf(w, "            return\n")
+		fmt.Fprintf(w, "            ;;\n")
+	}
+	fmt.Fprintf(w, "        -config|config|-answers|answers)\n")
+	fmt.Fprintf(w, "            COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        -dest|dest)\n")
+	fmt.Fprintf(w, "            COMPREPLY=($(compgen -d -- \"$cur\"))\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "    esac\n\n")
+	fmt.Fprintf(w, "    if [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(w, "        case \"$cmd\" in\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "            %s)\n", c.name)
+		fmt.Fprintf(w, "                opts=\"%s\"\n", strings.Join(completionOptions(c.options), " "))
+		fmt.Fprintf(w, "                ;;\n")
+	}
+	fmt.Fprintf(w, "            *)\n")
+	fmt.Fprintf(w, "                opts=\"%s\"\n", strings.Join(completionOptions(globalOptions()), " "))
+	fmt.Fprintf(w, "                ;;\n")
+	fmt.Fprintf(w, "        esac\n")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W \"$opts\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "        return\n")
+	fmt.Fprintf(w, "    fi\n\n")
+	fmt.Fprintf(w, "    case \"$cmd\" in\n")
+	fmt.Fprintf(w, "        \"\")\n")
+	fmt.Fprintf(w, "            COMPREPLY=($(compgen -W \"%s\" -- \"$cur\") $(compgen -f -- \"$cur\"))\n", names)
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        completion)\n")
+	fmt.Fprintf(w, "            COMPREPLY=($(compgen -W \"bash zsh fish\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        help)\n")
+	fmt.Fprintf(w, "            COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", names)
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        *)\n")
+	fmt.Fprintf(w, "            COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "    esac\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "complete -o filenames -F _homemaker homemaker\n")
+}
+
+func zshQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+func zshOptions(names []string) string {
+	var specs []string
+	for _, name := range names {
+		if f := flag.Lookup(name); f != nil {
+			specs = append(specs, zshQuote("-"+name+":"+strings.Replace(f.Usage, ":", "\\:", -1)))
+		}
+	}
+
+	return strings.Join(specs, " ")
+}
+
+func writeZshCompletion(w io.Writer) {
+	fmt.Fprintf(w, "#compdef homemaker\n\n")
+	fmt.Fprintf(w, "_homemaker() {\n")
+	fmt.Fprintf(w, "    local -a commands opts\n")
+	fmt.Fprintf(w, "    local cmd i prev\n\n")
+	fmt.Fprintf(w, "    commands=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "        %s\n", zshQuote(c.name+":"+strings.Replace(c.usage, ":", "\\:", -1)))
+	}
+	fmt.Fprintf(w, "    )\n\n")
+	fmt.Fprintf(w, "    for ((i = 2; i < CURRENT; i++)); do\n")
+	fmt.Fprintf(w, "        case ${words[i]} in\n")
+	fmt.Fprintf(w, "            (%s)\n", strings.Join(commandNames(), "|"))
+	fmt.Fprintf(w, "                cmd=${words[i]}\n")
+	fmt.Fprintf(w, "                break\n")
+	fmt.Fprintf(w, "                ;;\n")
+	fmt.Fprintf(w, "        esac\n")
+	fmt.Fprintf(w, "    done\n\n")
+	fmt.Fprintf(w, "    prev=${words[CURRENT-1]}\n")
+	fmt.Fprintf(w, "    case $prev in\n")
+	fmt.Fprintf(w, "        (-task|--task|-skip|--skip)\n")
+	fmt.Fprintf(w, "            compadd -- ${(f)\"$(homemaker __complete tasks ${words[2,CURRENT-1]} 2>/dev/null)\"}\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        (-tags|--tags|-skiptags|--skiptags)\n")
+	fmt.Fprintf(w, "            compadd -- ${(f)\"$(homemaker __complete tags ${words[2,CURRENT-1]} 2>/dev/null)\"}\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        (-variant|--variant)\n")
+	fmt.Fprintf(w, "            compadd -- ${(f)\"$(homemaker __complete variants ${words[2,CURRENT-1]} 2>/dev/null)\"}\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	for _, name := range sortedKeys(completionValues) {
+		fmt.Fprintf(w, "        (-%s|--%s)\n", name, name)
+		fmt.Fprintf(w, "            compadd -- %s\n", completionValues[name])
+		fmt.Fprintf(w, "            return\n")
+		fmt.Fprintf(w, "            ;;\n")
+	}
+	fmt.Fprintf(w, "        (-config|--config|-answers|--answers)\n")
+	fmt.Fprintf(w, "            _files\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        (-dest|--dest)\n")
+	fmt.Fprintf(w, "            _files -/\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "    esac\n\n")
+	fmt.Fprintf(w, "    if [[ ${words[CURRENT]} == -* ]]; then\n")
+	fmt.Fprintf(w, "        case $cmd in\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "            (%s)\n", c.name)
+		fmt.Fprintf(w, "                opts=(%s)\n", zshOptions(c.options))
+		fmt.Fprintf(w, "                ;;\n")
+	}
+	fmt.Fprintf(w, "            (*)\n")
+	fmt.Fprintf(w, "                opts=(%s)\n", zshOptions(globalOptions()))
+	fmt.Fprintf(w, "                ;;\n")
+	fmt.Fprintf(w, "        esac\n")
+	fmt.Fprintf(w, "        _describe -t options option opts\n")
+	fmt.Fprintf(w, "        return\n")
+	fmt.Fprintf(w, "    fi\n\n")
+	fmt.Fprintf(w, "    case $cmd in\n")
+	fmt.Fprintf(w, "        ('')\n")
+	fmt.Fprintf(w, "            _describe -t commands command commands\n")
+	fmt.Fprintf(w, "            _files\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        (completion)\n")
+	fmt.Fprintf(w, "            compadd -- bash zsh fish\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        (help)\n")
+	fmt.Fprintf(w, "            _describe -t commands command commands\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "        (*)\n")
+	fmt.Fprintf(w, "            _files\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "    esac\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "if [[ $zsh_eval_context[-1] == loadautofunc ]]; then\n")
+	fmt.Fprintf(w, "    _homemaker \"$@\"\n")
+	fmt.Fprintf(w, "else\n")
+	fmt.Fprintf(w, "    compdef _homemaker homemaker\n")
+	fmt.Fprintf(w, "fi\n")
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, "\\", "\\\\", -1), "'", "\\'", -1) + "'"
+}
+
+func writeFishCompletion(w io.Writer) {
+	names := strings.Join(commandNames(), " ")
+
+	fmt.Fprintf(w, "# fish completion for homemaker\n\n")
+	fmt.Fprintf(w, "set -l commands %s\n\n", names)
+	fmt.Fprintf(w, "function __homemaker_complete\n")
+	fmt.Fprintf(w, "    homemaker __complete $argv (commandline -opc)[2..-1] 2>/dev/null\n")
+	fmt.Fprintf(w, "end\n\n")
+
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c homemaker -n \"not __fish_seen_subcommand_from $commands\" -a %s -d %s\n",
+			c.name, fishQuote(c.usage))
+	}
+	fmt.Fprintf(w, "complete -c homemaker -f -n \"__fish_seen_subcommand_from completion\" -a \"bash zsh fish\"\n")
+	fmt.Fprintf(w, "complete -c homemaker -f -n \"__fish_seen_subcommand_from help\" -a \"$commands\"\n\n")
+
+	for _, name := range globalOptions() {
+		var accepting []string
+		for _, c := range commands {
+			for _, option := range c.options {
+				if option == name {
+					accepting = append(accepting, c.name)
+				}
+			}
+		}
+
+		condition := "not __fish_seen_subcommand_from $commands"
+		if len(accepting) > 0 {
+			condition += "; or __fish_seen_subcommand_from " + strings.Join(accepting, " ")
+		}
+
+		spec := fmt.Sprintf("complete -c homemaker -n %s -o %s -d %s", fishQuote(condition), name,
+			fishQuote(flag.Lookup(name).Usage))
+
+		switch {
+		case name == "task" || name == "skip":
+			spec += " -x -a '(__homemaker_complete tasks)'"
+		case name == "tags" || name == "skiptags":
+			spec += " -x -a '(__homemaker_complete tags)'"
+		case name == "variant":
+			spec += " -x -a '(__homemaker_complete variants)'"
+		case len(completionValues[name]) > 0:
+			spec += fmt.Sprintf(" -x -a %s", fishQuote(completionValues[name]))
+		case name == "dest":
+			spec += " -x -a '(__fish_complete_directories)'"
+		case !isBoolOption(name):
+			spec += " -r -F"
+		}
+
+		fmt.Fprintln(w, strings.Replace(spec, "$commands", names, -1))
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func printCompletion(shell string) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(os.Stdout)
+	case "zsh":
+		writeZshCompletion(os.Stdout)
+	case "fish":
+		writeFishCompletion(os.Stdout)
+	default:
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+
+	return nil
+}
+
+func completionConfigFiles(words []string) []string {
+	var confFiles []string
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		switch {
+		case word == "-config" || word == "--config":
+			if i+1 < len(words) {
+				confFiles = append(confFiles, words[i+1])
+				i++
+			}
+		case strings.HasPrefix(word, "-config=") || strings.HasPrefix(word, "--config="):
+			confFiles = append(confFiles, word[strings.Index(word, "=")+1:])
+		case !strings.HasPrefix(word, "-") && findCommand(word) == nil && len(confFiles) == 0 && isConfigFile(word):
+			if info, err := os.Stat(word); err == nil && info.Mode().IsRegular() {
+				confFiles = append(confFiles, word)
+			}
+		}
+	}
+
+	if len(confFiles) == 0 {
+		if confFile, err := findConfig(); err == nil {
+			confFiles = append(confFiles, confFile)
+		}
+	}
+
+	for i, confFile := range confFiles {
+		confFiles[i] = makeAbsPath(os.ExpandEnv(confFile))
+	}
+
+	return confFiles
+}
+
+func printCompletionNames(kind string, words []string) {
+	confFiles := completionConfigFiles(words)
+	if len(confFiles) == 0 {
+		return
+	}
+
+	conf, err := newConfig(confFiles, mergeOverride)
+	if err != nil {
+		return
+	}
+
+	found := make(map[string]bool)
+	addName := func(name string, hidden bool) {
+		variant := ""
+		if nameParts := strings.Split(name, "__"); len(nameParts) > 1 {
+			name = strings.Join(nameParts[:len(nameParts)-1], "__")
+			variant = nameParts[len(nameParts)-1]
+		}
+
+		switch kind {
+		case "tasks":
+			if !hidden {
+				found[name] = true
+			}
+		case "variants":
+			found[variant] = true
+		}
+	}
+
+	for tn, t := range conf.Tasks {
+		addName(tn, t.Hidden)
+		if kind == "tags" {
+			for _, tag := range t.Tags {
+				found[tag] = true
+			}
+		}
+	}
+	if kind == "variants" {
+		for mn := range conf.Macros {
+			addName(mn, false)
+		}
+	}
+
+	var names []string
+	for name := range found {
+		if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
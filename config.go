@@ -26,45 +26,322 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 
 	"github.com/naoina/toml"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	mergeError    = "error"
+	mergeOverride = "override"
+	mergeAppend   = "append"
+)
+
+var configExts = []string{".toml", ".tml", ".yaml", ".yml", ".json"}
+
 type config struct {
-	Tasks  map[string]task
-	Macros map[string]macro
-
-	handled map[string]bool
-	srcDir  string
-	dstDir  string
-	variant string
-	flags   int
+	Schema   string                 `json:"$schema,omitempty" yaml:"$schema,omitempty" toml:"-"`
+	Include  []string               `json:"include,omitempty" yaml:"include,omitempty" toml:",omitempty"`
+	Variant  string                 `json:"variant,omitempty" yaml:"variant,omitempty" toml:",omitempty"`
+	EnvScope string                 `json:"envscope,omitempty" yaml:"envscope,omitempty" toml:",omitempty"`
+	Src      string                 `json:"src,omitempty" yaml:"src,omitempty" toml:",omitempty"`
+	Vars     map[string]interface{} `json:"vars,omitempty" yaml:"vars,omitempty" toml:",omitempty"`
+	Tasks    map[string]task        `json:"tasks,omitempty" yaml:"tasks,omitempty" toml:",omitempty"`
+	Macros   map[string]macro       `json:"macros,omitempty" yaml:"macros,omitempty" toml:",omitempty"`
+
+	origins   map[string]string
+	handled   map[string]bool
+	failed    map[string]bool
+	failures  []failure
+	srcDir    string
+	dstDir    string
+	variant   string
+	envScope  string
+	flags     int
+	task      string
+	state     *state
+	graph     *taskGraph
+	policy    policy
+	problems  []problem
+	nodes     map[string]*configNode
+	overrides map[string]interface{}
+	exports   map[string]map[string]string
 }
 
-func newConfig(filename string) (*config, error) {
+func unmarshalFile(filename string, v interface{}) error {
 	bytes, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	conf := &config{handled: make(map[string]bool)}
 	switch filepath.Ext(filename) {
 	case ".json":
-		if err := json.Unmarshal(bytes, &conf); err != nil {
-			return nil, err
-		}
+		return json.Unmarshal(bytes, v)
 	case ".toml", ".tml":
-		if err := toml.Unmarshal(bytes, &conf); err != nil {
-			return nil, err
-		}
+		return toml.Unmarshal(bytes, v)
 	case ".yaml", ".yml":
-		if err := yaml.Unmarshal(bytes, &conf); err != nil {
-			return nil, err
+		return yaml.Unmarshal(bytes, v)
+	default:
+		return fmt.Errorf("unsupported configuration file format")
+	}
+}
+
+func decodeConfig(filename string, data []byte, conf *config) error {
+	switch filepath.Ext(filename) {
+	case ".json":
+		return json.Unmarshal(data, conf)
+	case ".toml", ".tml":
+		tomlConf := toml.DefaultConfig
+		tomlConf.MissingField = func(_ reflect.Type, _ string) error { return nil }
+		return tomlConf.Unmarshal(data, conf)
+	default:
+		return yaml.Unmarshal(data, conf)
+	}
+}
+
+func decodeError(filename string, data []byte, err error) error {
+	switch err := err.(type) {
+	case *json.SyntaxError:
+		line, col := bytePosition(data, int(err.Offset))
+		return fmt.Errorf("%s:%d:%d: %w", filename, line, col, err)
+	case *json.UnmarshalTypeError:
+		line, col := bytePosition(data, int(err.Offset))
+		return fmt.Errorf("%s:%d:%d: %w", filename, line, col, err)
+	default:
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+}
+
+func marshalConfig(conf *config, format string) ([]byte, error) {
+	return marshalOrdered(orderValue(reflect.ValueOf(*conf), nil, nil).(orderedMap), format)
+}
+
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "homemaker")
+	}
+
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".config", "homemaker")
+}
+
+func findConfig() (string, error) {
+	workDir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	var dirs []string
+	for dir := workDir; ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	dirs = append(dirs, configDir())
+
+	for _, dir := range dirs {
+		for _, ext := range configExts {
+			filename := filepath.Join(dir, "homemaker"+ext)
+			if info, err := os.Stat(filename); err == nil && info.Mode().IsRegular() {
+				return filename, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no configuration file found in %s or its parents, or in %s", workDir, configDir())
+}
+
+func isConfigFile(path string) bool {
+	if info, err := os.Stat(path); err == nil {
+		return info.Mode().IsRegular()
+	}
+
+	for _, ext := range configExts {
+		if filepath.Ext(path) == ext {
+			return true
+		}
+	}
+
+	return false
+}
+
+func findLocalConfig(filename string) string {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	if strings.HasSuffix(base, ".local") {
+		return ""
+	}
+
+	for _, localExt := range append([]string{ext}, configExts...) {
+		localFile := base + ".local" + localExt
+		if _, err := os.Stat(localFile); err == nil {
+			return localFile
+		}
+	}
+
+	return ""
+}
+
+func (conf *config) merge(other *config, filename, mode string) error {
+	if len(other.Variant) > 0 {
+		conf.Variant = other.Variant
+	}
+	if len(other.EnvScope) > 0 {
+		conf.EnvScope = other.EnvScope
+	}
+	if len(other.Src) > 0 {
+		conf.Src = other.Src
+	}
+	if len(other.Vars) > 0 {
+		conf.Vars = mergeVars(conf.Vars, other.Vars)
+	}
+
+	for tn, t := range other.Tasks {
+		if prev, ok := conf.Tasks[tn]; ok {
+			switch mode {
+			case mergeError:
+				return fmt.Errorf("task %s defined in both %s and %s", tn, conf.origins["tasks."+tn], filename)
+			case mergeAppend:
+				t = prev.append(t)
+			}
+		}
+
+		conf.Tasks[tn] = t
+		conf.origins["tasks."+tn] = filename
+	}
+
+	for mn, m := range other.Macros {
+		if prev, ok := conf.Macros[mn]; ok {
+			switch mode {
+			case mergeError:
+				return fmt.Errorf("macro %s defined in both %s and %s", mn, conf.origins["macros."+mn], filename)
+			case mergeAppend:
+				m = prev.append(m)
+			}
+		}
+
+		conf.Macros[mn] = m
+		conf.origins["macros."+mn] = filename
+	}
+
+	return nil
+}
+
+func (conf *config) parseFile(filename string) (*config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := parseConfigNode(filename, data)
+	if err != nil {
+		return nil, decodeError(filename, data, err)
+	}
+
+	conf.nodes[filename] = node
+	conf.checkStructure(filename, node)
+
+	fileConf := new(config)
+	if err := decodeConfig(filename, data, fileConf); err != nil {
+		return nil, decodeError(filename, data, err)
+	}
+
+	normalizeVars(fileConf.Vars)
+	for tn, t := range fileConf.Tasks {
+		normalizeVars(t.Vars)
+		fileConf.Tasks[tn] = t
+	}
+
+	conf.checkEntries(filename, node, fileConf)
+	return fileConf, nil
+}
+
+func (conf *config) load(filename, mode string, loaded map[string]bool) error {
+	if loaded[filename] {
+		return nil
+	}
+
+	loaded[filename] = true
+
+	fileConf, err := conf.parseFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if len(fileConf.Src) > 0 {
+		if fileConf.Src, err = expandEnv(fileConf.Src); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		if !filepath.IsAbs(fileConf.Src) {
+			fileConf.Src = filepath.Join(filepath.Dir(filename), fileConf.Src)
+		}
+	}
+
+	for _, include := range fileConf.Include {
+		if include, err = expandEnv(include); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
 		}
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filename), include)
+		}
+
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(include, "*?[") {
+			return fmt.Errorf("%s: included file %s does not exist", filename, include)
+		}
+
+		for _, match := range matches {
+			if err := conf.load(match, mode, loaded); err != nil {
+				return err
+			}
+		}
+	}
+
+	return conf.merge(fileConf, filename, mode)
+}
+
+func newConfig(filenames []string, mode string) (*config, error) {
+	switch mode {
+	case mergeError, mergeOverride, mergeAppend:
 	default:
-		return nil, fmt.Errorf("unsupported configuration file format")
+		return nil, fmt.Errorf("invalid merge mode: %s", mode)
+	}
+
+	conf := &config{
+		Tasks:   make(map[string]task),
+		Macros:  make(map[string]macro),
+		origins: make(map[string]string),
+		handled: make(map[string]bool),
+		failed:  make(map[string]bool),
+		nodes:   make(map[string]*configNode),
+	}
+
+	loaded := make(map[string]bool)
+	for _, filename := range filenames {
+		if err := conf.load(filename, mode, loaded); err != nil {
+			return nil, err
+		}
+	}
+
+	localMode := mergeAppend
+	if mode == mergeOverride {
+		localMode = mergeOverride
+	}
+
+	for _, filename := range filenames {
+		if localFile := findLocalConfig(filename); len(localFile) > 0 {
+			if err := conf.load(localFile, localMode, loaded); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return conf, nil
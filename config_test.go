@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestMergeModes(t *testing.T) {
+	files := map[string]string{
+		"a.toml": `
+[tasks.shared]
+deps = ["one"]
+links = [["a", "a"]]
+
+[macros.m]
+deps = ["one"]
+prefix = ["a"]
+`,
+		"b.toml": `
+[tasks.shared]
+deps = ["two"]
+links = [["b", "b"]]
+
+[macros.m]
+deps = ["two"]
+`,
+	}
+
+	tests := []struct {
+		mode      string
+		err       string
+		deps      []string
+		links     [][]string
+		macroDeps []string
+		prefix    []string
+	}{
+		{mode: mergeError, err: "task shared defined in both"},
+		{
+			mode:      mergeOverride,
+			deps:      []string{"two"},
+			links:     [][]string{{"b", "b"}},
+			macroDeps: []string{"two"},
+		},
+		{
+			mode:      mergeAppend,
+			deps:      []string{"one", "two"},
+			links:     [][]string{{"a", "a"}, {"b", "b"}},
+			macroDeps: []string{"one", "two"},
+			prefix:    []string{"a"},
+		},
+	}
+
+	dir := writeConfigFiles(t, files)
+	filenames := []string{filepath.Join(dir, "a.toml"), filepath.Join(dir, "b.toml")}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			conf, err := newConfig(filenames, test.mode)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			task := conf.Tasks["shared"]
+			if !reflect.DeepEqual(task.Deps, test.deps) {
+				t.Errorf("deps: expected %v, got %v", test.deps, task.Deps)
+			}
+			if !reflect.DeepEqual(task.Links, test.links) {
+				t.Errorf("links: expected %v, got %v", test.links, task.Links)
+			}
+
+			macro := conf.Macros["m"]
+			if !reflect.DeepEqual(macro.Deps, test.macroDeps) {
+				t.Errorf("macro deps: expected %v, got %v", test.macroDeps, macro.Deps)
+			}
+			if !reflect.DeepEqual(macro.Prefix, test.prefix) {
+				t.Errorf("macro prefix: expected %v, got %v", test.prefix, macro.Prefix)
+			}
+
+			if origin := conf.origins["tasks.shared"]; origin != filenames[1] {
+				t.Errorf("origin: expected %s, got %s", filenames[1], origin)
+			}
+		})
+	}
+}
+
+func TestMergeErrorOrigins(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"main.toml":  "include = [\"inc.toml\"]\n\n[macros.m]\nprefix = [\"a\"]\n",
+		"inc.toml":   "[macros.m]\nprefix = [\"b\"]\n",
+		"other.toml": "[tasks.t]\ndeps = [\"x\"]\n",
+		"third.toml": "[tasks.t]\ndeps = [\"y\"]\n",
+	})
+
+	tests := []struct {
+		files []string
+		err   string
+	}{
+		{
+			files: []string{"main.toml"},
+			err:   "macro m defined in both " + filepath.Join(dir, "inc.toml") + " and " + filepath.Join(dir, "main.toml"),
+		},
+		{
+			files: []string{"other.toml", "third.toml"},
+			err:   "task t defined in both " + filepath.Join(dir, "other.toml") + " and " + filepath.Join(dir, "third.toml"),
+		},
+	}
+
+	for _, test := range tests {
+		var filenames []string
+		for _, file := range test.files {
+			filenames = append(filenames, filepath.Join(dir, file))
+		}
+
+		_, err := newConfig(filenames, mergeError)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("expected error %q, got %v", test.err, err)
+		}
+	}
+}
+
+func TestMergeIncludes(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"main.toml": "include = [\"inc.toml\"]\n\n[tasks.t]\ncmds = [[\"main\"]]\n",
+		"inc.toml":  "[tasks.t]\ncmds = [[\"inc\"]]\n\n[tasks.only]\ncmds = [[\"only\"]]\n",
+	})
+
+	tests := []struct {
+		mode string
+		cmds [][]string
+	}{
+		{mode: mergeOverride, cmds: [][]string{{"main"}}},
+		{mode: mergeAppend, cmds: [][]string{{"inc"}, {"main"}}},
+	}
+
+	for _, test := range tests {
+		conf, err := newConfig([]string{filepath.Join(dir, "main.toml")}, test.mode)
+		if err != nil {
+			t.Fatalf("%s: %v", test.mode, err)
+		}
+		if cmds := conf.Tasks["t"].Cmds; !reflect.DeepEqual(cmds, test.cmds) {
+			t.Errorf("%s: expected %v, got %v", test.mode, test.cmds, cmds)
+		}
+		if _, ok := conf.Tasks["only"]; !ok {
+			t.Errorf("%s: task from included file is missing", test.mode)
+		}
+		if origin := conf.origins["tasks.only"]; origin != filepath.Join(dir, "inc.toml") {
+			t.Errorf("%s: unexpected origin %s", test.mode, origin)
+		}
+	}
+}
+
+func TestMergeLocal(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"main.toml": "[tasks.t]\ndescription = \"shared\"\ntags = [\"dev\"]\ndeps = [\"base\"]\n" +
+			"links = [[\".vimrc\"]]\n",
+		"main.local.toml": "variant = \"work\"\n\n[tasks.t]\ndescription = \"local\"\nlinks = [[\".netrc\"]]\n",
+	})
+
+	tests := []struct {
+		mode  string
+		deps  []string
+		tags  []string
+		links [][]string
+	}{
+		{mode: mergeError, deps: []string{"base"}, tags: []string{"dev"}, links: [][]string{{".vimrc"}, {".netrc"}}},
+		{mode: mergeAppend, deps: []string{"base"}, tags: []string{"dev"}, links: [][]string{{".vimrc"}, {".netrc"}}},
+		{mode: mergeOverride, links: [][]string{{".netrc"}}},
+	}
+
+	for _, test := range tests {
+		conf, err := newConfig([]string{filepath.Join(dir, "main.toml")}, test.mode)
+		if err != nil {
+			t.Fatalf("%s: %v", test.mode, err)
+		}
+
+		task := conf.Tasks["t"]
+		if !reflect.DeepEqual(task.Deps, test.deps) {
+			t.Errorf("%s: deps: expected %v, got %v", test.mode, test.deps, task.Deps)
+		}
+		if !reflect.DeepEqual(task.Tags, test.tags) {
+			t.Errorf("%s: tags: expected %v, got %v", test.mode, test.tags, task.Tags)
+		}
+		if !reflect.DeepEqual(task.Links, test.links) {
+			t.Errorf("%s: links: expected %v, got %v", test.mode, test.links, task.Links)
+		}
+		if task.Description != "local" {
+			t.Errorf("%s: expected description from local file, got %q", test.mode, task.Description)
+		}
+		if conf.Variant != "work" {
+			t.Errorf("%s: expected variant from local file, got %q", test.mode, conf.Variant)
+		}
+	}
+}
+
+func TestTaskAppend(t *testing.T) {
+	base := task{
+		Description: "base",
+		Tags:        []string{"a"},
+		Vars:        map[string]interface{}{"x": "1", "y": "1"},
+		Cmds:        [][]string{{"one"}},
+	}
+	other := task{
+		Hidden: true,
+		Tags:   []string{"b"},
+		Vars:   map[string]interface{}{"y": "2"},
+		Cmds:   [][]string{{"two"}},
+	}
+
+	merged := base.append(other)
+	expected := task{
+		Description: "base",
+		Hidden:      true,
+		Tags:        []string{"a", "b"},
+		Vars:        map[string]interface{}{"x": "1", "y": "2"},
+		Cmds:        [][]string{{"one"}, {"two"}},
+	}
+
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected %+v, got %+v", expected, merged)
+	}
+	if base.Vars["y"] != "1" {
+		t.Errorf("append modified the original vars")
+	}
+}
+
+func TestMacroAppend(t *testing.T) {
+	tests := []struct {
+		base     macro
+		other    macro
+		expected macro
+	}{
+		{
+			base:     macro{Description: "a", Deps: []string{"x"}, Prefix: []string{"a"}, Suffix: []string{"s"}},
+			other:    macro{Deps: []string{"y"}, Prefix: []string{"b"}},
+			expected: macro{Description: "a", Deps: []string{"x", "y"}, Prefix: []string{"b"}, Suffix: []string{"s"}},
+		},
+		{
+			base:     macro{Prefix: []string{"a"}},
+			other:    macro{Description: "b", Hidden: true},
+			expected: macro{Description: "b", Hidden: true, Prefix: []string{"a"}},
+		},
+	}
+
+	for _, test := range tests {
+		if merged := test.base.append(test.other); !reflect.DeepEqual(merged, test.expected) {
+			t.Errorf("expected %+v, got %+v", test.expected, merged)
+		}
+	}
+}
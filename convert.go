@@ -0,0 +1,475 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var tomlBareKeyExp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type orderedField struct {
+	key     string
+	value   interface{}
+	comment string
+}
+
+type orderedMap []orderedField
+
+func encodeJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (m orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := encodeJSON(field.key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := encodeJSON(field.value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func yamlValue(value interface{}) (*yaml.Node, error) {
+	switch value := value.(type) {
+	case orderedMap:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, field := range value {
+			key := &yaml.Node{Kind: yaml.ScalarNode, Value: field.key, HeadComment: field.comment}
+			child, err := yamlValue(field.value)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, key, child)
+		}
+		return node, nil
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range value {
+			child, err := yamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	default:
+		node := new(yaml.Node)
+		err := node.Encode(value)
+		return node, err
+	}
+}
+
+func orderValue(value reflect.Value, node *configNode, normalize func(string) string) interface{} {
+	child := func(key string) *configNode {
+		if node == nil {
+			return nil
+		}
+		return node.field(key, normalize)
+	}
+
+	comment := func(key string) string {
+		if child := child(key); child != nil {
+			return child.comment
+		}
+		return ""
+	}
+
+	if value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		var m orderedMap
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			fieldValue := value.Field(i)
+			if len(field.PkgPath) > 0 || fieldValue.IsZero() {
+				continue
+			}
+			if kind := fieldValue.Kind(); (kind == reflect.Map || kind == reflect.Slice) && fieldValue.Len() == 0 {
+				continue
+			}
+
+			key := strings.Split(field.Tag.Get("json"), ",")[0]
+			m = append(m, orderedField{key, orderValue(fieldValue, child(key), normalize), comment(key)})
+		}
+		return m
+	case reflect.Map:
+		var keys []string
+		for _, key := range value.MapKeys() {
+			keys = append(keys, key.String())
+		}
+
+		position := func(key string) int {
+			if node != nil {
+				for i, k := range node.keys {
+					if k == key {
+						return i
+					}
+				}
+			}
+			return len(keys)
+		}
+
+		sort.SliceStable(keys, func(i, j int) bool {
+			if pi, pj := position(keys[i]), position(keys[j]); pi != pj {
+				return pi < pj
+			}
+			return keys[i] < keys[j]
+		})
+
+		var m orderedMap
+		for _, key := range keys {
+			fieldValue := orderValue(value.MapIndex(reflect.ValueOf(key)), child(key), normalize)
+			m = append(m, orderedField{key, fieldValue, comment(key)})
+		}
+		return m
+	case reflect.Slice:
+		if kind := value.Type().Elem().Kind(); kind != reflect.Interface && kind != reflect.Map {
+			return value.Interface()
+		}
+
+		items := make([]interface{}, value.Len())
+		for i := range items {
+			var item *configNode
+			if node != nil && i < len(node.items) {
+				item = node.items[i]
+			}
+			items[i] = orderValue(value.Index(i), item, normalize)
+		}
+		return items
+	default:
+		return value.Interface()
+	}
+}
+
+func tomlKey(key string) string {
+	if tomlBareKeyExp.MatchString(key) {
+		return key
+	}
+
+	bytes, _ := encodeJSON(key)
+	return string(bytes)
+}
+
+func tomlValue(value interface{}, indent string) (string, error) {
+	switch value := value.(type) {
+	case orderedMap:
+		var fields []string
+		for _, field := range value {
+			line, err := tomlValue(field.value, indent)
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, tomlKey(field.key)+" = "+line)
+		}
+		return "{" + strings.Join(fields, ", ") + "}", nil
+	case [][]string:
+		var items []string
+		for _, item := range value {
+			line, err := tomlValue(item, indent)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, line)
+		}
+
+		if len(items) < 2 {
+			return "[" + strings.Join(items, ", ") + "]", nil
+		}
+
+		return fmt.Sprintf("[\n%s    %s,\n%s]", indent, strings.Join(items, ",\n"+indent+"    "), indent), nil
+	case []interface{}:
+		var items []string
+		var kind string
+		for _, item := range value {
+			line, err := tomlValue(item, indent)
+			if err != nil {
+				return "", err
+			}
+			if curr := tomlKind(item, line); len(kind) == 0 {
+				kind = curr
+			} else if curr != kind {
+				return "", fmt.Errorf("array mixes %s and %s values, which TOML does not support", kind, curr)
+			}
+			items = append(items, line)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case []string:
+		var items []string
+		for _, item := range value {
+			line, err := tomlValue(item, indent)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, line)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case nil:
+		return "", fmt.Errorf("null values are not supported by TOML")
+	default:
+		bytes, err := encodeJSON(value)
+		return string(bytes), err
+	}
+}
+
+func tomlKind(value interface{}, encoded string) string {
+	switch value.(type) {
+	case orderedMap:
+		return "table"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case []interface{}, []string, [][]string:
+		return "array"
+	}
+
+	if strings.ContainsAny(encoded, ".eE") {
+		return "float"
+	}
+
+	return "integer"
+}
+
+func tomlTables(value interface{}) []orderedMap {
+	items, ok := value.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil
+	}
+
+	var tables []orderedMap
+	for _, item := range items {
+		table, ok := item.(orderedMap)
+		if !ok {
+			return nil
+		}
+		tables = append(tables, table)
+	}
+
+	return tables
+}
+
+func writeComment(buf *bytes.Buffer, comment, indent string) {
+	if len(comment) == 0 {
+		return
+	}
+
+	for _, line := range strings.Split(comment, "\n") {
+		fmt.Fprintf(buf, "%s%s\n", indent, line)
+	}
+}
+
+func writeTOML(buf *bytes.Buffer, m orderedMap, path []string, comment string) error {
+	indent := ""
+	if len(path) > 0 {
+		indent = "    "
+	}
+
+	var tables []orderedField
+	for _, field := range m {
+		_, ok := field.value.(orderedMap)
+		if ok || tomlTables(field.value) != nil {
+			tables = append(tables, field)
+			continue
+		}
+
+		value, err := tomlValue(field.value, indent)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(append(append([]string(nil), path...), tomlKey(field.key)), "."), err)
+		}
+
+		writeComment(buf, field.comment, indent)
+		fmt.Fprintf(buf, "%s%s = %s\n", indent, tomlKey(field.key), value)
+	}
+
+	for i, table := range tables {
+		subPath := append(append([]string(nil), path...), tomlKey(table.key))
+		if i == 0 && len(comment) > 0 {
+			table.comment = strings.TrimPrefix(comment+"\n"+table.comment, "\n")
+		}
+
+		if items := tomlTables(table.value); items != nil {
+			for j, item := range items {
+				if buf.Len() > 0 {
+					buf.WriteByte('\n')
+				}
+				if j == 0 {
+					writeComment(buf, table.comment, "")
+				}
+				fmt.Fprintf(buf, "[[%s]]\n", strings.Join(subPath, "."))
+
+				if err := writeTOML(buf, item, subPath, ""); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		sub := table.value.(orderedMap)
+		header := len(sub) == 0
+		for _, field := range sub {
+			if _, ok := field.value.(orderedMap); !ok && tomlTables(field.value) == nil {
+				header = true
+			}
+		}
+
+		if !header {
+			if err := writeTOML(buf, sub, subPath, table.comment); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		writeComment(buf, table.comment, "")
+		fmt.Fprintf(buf, "[%s]\n", strings.Join(subPath, "."))
+
+		if err := writeTOML(buf, sub, subPath, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasComments(filename string, data []byte) bool {
+	if filepath.Ext(filename) == ".json" {
+		return false
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func convertConfig(filename, format string) ([]byte, error) {
+	if len(format) == 0 {
+		format = strings.TrimPrefix(filepath.Ext(filename), ".")
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &config{nodes: make(map[string]*configNode)}
+	fileConf, err := conf.parseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := conf.validate([]string{}); err != nil {
+		return nil, err
+	}
+
+	if format == "json" && hasComments(filename, data) {
+		log.Printf("%s: comments are not preserved in JSON", filename)
+	}
+
+	ordered := orderValue(reflect.ValueOf(*fileConf), conf.nodes[filename], keyNormalizer(filename)).(orderedMap)
+	return marshalOrdered(ordered, format)
+}
+
+func marshalOrdered(ordered orderedMap, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "    ")
+		err := enc.Encode(ordered)
+		return buf.Bytes(), err
+	case "toml", "tml":
+		var buf bytes.Buffer
+		for i, field := range ordered {
+			if field.key == "$schema" {
+				ordered = append(ordered[:i:i], ordered[i+1:]...)
+				break
+			}
+		}
+		err := writeTOML(&buf, ordered, nil, "")
+		return buf.Bytes(), err
+	case "yaml", "yml":
+		node, err := yamlValue(ordered)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(node); err != nil {
+			return nil, err
+		}
+		err = enc.Close()
+		return buf.Bytes(), err
+	default:
+		return nil, fmt.Errorf("unsupported configuration file format")
+	}
+}
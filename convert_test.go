@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const convertJSON = `{
+    "include": ["other.json"],
+    "variant": "arch",
+    "vars": {
+        "editor": "vim",
+        "count": 3,
+        "ratio": 1.5,
+        "enabled": true,
+        "names": ["a", "b"],
+        "proxy": {"host": "localhost", "port": 8080},
+        "hosts": [
+            {"name": "alpha", "port": 22, "opts": {"user": "root"}},
+            {"name": "beta", "tags": ["x"]}
+        ]
+    },
+    "tasks": {
+        "vim": {
+            "description": "editor \"config\"",
+            "deps": ["git"],
+            "links": [[".vimrc"], [".vim", "vim", "0755"]],
+            "cmds": [["echo", "$HOME", "a\\b"]],
+            "envs": [["EDITOR", "vim"]],
+            "vars": {"theme": "dark"}
+        },
+        "git": {
+            "hidden": true,
+            "templates": [[".gitconfig"]]
+        },
+        "with space": {
+            "cmds": [["true"]]
+        }
+    },
+    "macros": {
+        "install": {"prefix": ["apt-get", "install"], "deps": ["update"]}
+    }
+}`
+
+const convertYAML = `# shared settings
+vars:
+  editor: vim
+  count: 3
+  # list of hosts
+  hosts:
+    - name: alpha
+      port: 22
+    - name: beta
+tasks:
+  # the editor
+  vim:
+    deps: [git]
+    links:
+      - [.vimrc]
+      - [.vim, vim]
+  git:
+    cmds:
+      - [git, config, --global, user.name, "${NAME}"]
+macros:
+  install:
+    prefix: [apt-get, install]
+`
+
+func convertFile(t *testing.T, dir, name, data, format string) []byte {
+	t.Helper()
+
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := convertConfig(filename, format)
+	if err != nil {
+		t.Fatalf("converting %s to %s: %v", name, format, err)
+	}
+
+	return output
+}
+
+func decodeJSON(t *testing.T, data []byte) interface{} {
+	t.Helper()
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+
+	return value
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "config.json", data: convertJSON},
+		{name: "config.yaml", data: convertYAML},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		expected := convertFile(t, dir, test.name, test.data, "json")
+
+		toml := convertFile(t, dir, test.name, test.data, "toml")
+		actual := convertFile(t, dir, "converted.toml", string(toml), "json")
+		if !reflect.DeepEqual(decodeJSON(t, actual), decodeJSON(t, expected)) {
+			t.Errorf("%s: round trip through TOML changed the configuration:\n%s\nTOML:\n%s", test.name, actual, toml)
+		}
+
+		again := convertFile(t, dir, "again.toml", string(toml), "toml")
+		if string(again) != string(toml) {
+			t.Errorf("%s: reformatting the converted TOML changed it:\n%s\nexpected:\n%s", test.name, again, toml)
+		}
+
+		yaml := convertFile(t, dir, "converted.toml", string(toml), "yaml")
+		actual = convertFile(t, dir, "converted.yaml", string(yaml), "json")
+		if !reflect.DeepEqual(decodeJSON(t, actual), decodeJSON(t, expected)) {
+			t.Errorf("%s: round trip through YAML changed the configuration:\n%s", test.name, actual)
+		}
+	}
+}
+
+func TestConvertTOMLLayout(t *testing.T) {
+	toml := string(convertFile(t, t.TempDir(), "config.json", convertJSON, "toml"))
+
+	for _, expected := range []string{
+		"[[vars.hosts]]\n    name = \"alpha\"\n",
+		"[vars.hosts.opts]\n    user = \"root\"\n",
+		"[tasks.\"with space\"]\n",
+		"[vars.proxy]\n    host = \"localhost\"\n    port = 8080\n",
+	} {
+		if !strings.Contains(toml, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, toml)
+		}
+	}
+}
+
+func TestConvertComments(t *testing.T) {
+	dir := t.TempDir()
+	toml := string(convertFile(t, dir, "config.yaml", convertYAML, "toml"))
+
+	for _, expected := range []string{
+		"# shared settings\n[vars]\n",
+		"# list of hosts\n[[vars.hosts]]\n",
+		"# the editor\n[tasks.vim]\n",
+	} {
+		if !strings.Contains(toml, expected) {
+			t.Errorf("expected TOML output to contain %q:\n%s", expected, toml)
+		}
+	}
+
+	yaml := string(convertFile(t, dir, "converted.toml", toml, "yaml"))
+	for _, expected := range []string{"# shared settings\nvars:\n", "  # list of hosts\n  hosts:\n", "  # the editor\n  vim:\n"} {
+		if !strings.Contains(yaml, expected) {
+			t.Errorf("expected YAML output to contain %q:\n%s", expected, yaml)
+		}
+	}
+}
+
+func TestConvertTOMLErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{data: `{"vars": {"mixed": [1, "two"]}}`, err: "vars.mixed: array mixes integer and string values"},
+		{data: `{"vars": {"nums": [1, 2.5]}}`, err: "vars.nums: array mixes integer and float values"},
+		{data: `{"vars": {"items": [{"a": 1}, 2]}}`, err: "vars.items: array mixes table and integer values"},
+		{data: `{"vars": {"empty": null}}`, err: "vars.empty: null values are not supported by TOML"},
+	}
+
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), "config.json")
+		if err := ioutil.WriteFile(filename, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := convertConfig(filename, "toml")
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.data, test.err, err)
+		}
+	}
+}
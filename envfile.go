@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type envEntry struct {
+	name    string
+	value   string
+	literal bool
+}
+
+type envParser struct {
+	data string
+	pos  int
+	line int
+}
+
+func (p *envParser) skip(chars string) {
+	for p.pos < len(p.data) && strings.IndexByte(chars, p.data[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *envParser) skipLine() {
+	for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+func (p *envParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *envParser) name() string {
+	start := p.pos
+	for p.pos < len(p.data) && isNameByte(p.data[p.pos]) {
+		p.pos++
+	}
+
+	return p.data[start:p.pos]
+}
+
+func (p *envParser) quoted(quote byte) (string, error) {
+	line := p.line
+	p.pos++
+
+	var b strings.Builder
+	for ; p.pos < len(p.data); p.pos++ {
+		c := p.data[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), nil
+		case c == '\n':
+			p.line++
+		case c == '\\' && quote == '"' && p.pos+1 < len(p.data):
+			p.pos++
+			switch c = p.data[p.pos]; c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'r':
+				c = '\r'
+			case '$':
+				b.WriteByte('$')
+			case '"', '\\':
+			case '\n':
+				p.line++
+				continue
+			default:
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteByte(c)
+	}
+
+	return "", fmt.Errorf("line %d: unterminated quoted value", line)
+}
+
+func (p *envParser) unquoted() string {
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		if p.data[p.pos] == '#' && p.pos > start && strings.IndexByte(" \t", p.data[p.pos-1]) >= 0 {
+			break
+		}
+		p.pos++
+	}
+
+	return strings.TrimSpace(p.data[start:p.pos])
+}
+
+func parseEnvFile(data string) ([]envEntry, error) {
+	p := &envParser{data: strings.ReplaceAll(data, "\r\n", "\n")}
+
+	var entries []envEntry
+	for p.line = 1; p.pos < len(p.data); p.line++ {
+		p.skip(" \t")
+		if p.pos == len(p.data) || p.data[p.pos] == '\n' || p.data[p.pos] == '#' {
+			p.skipLine()
+			p.pos++
+			continue
+		}
+
+		if strings.HasPrefix(p.data[p.pos:], "export") {
+			next := p.pos + len("export")
+			if next < len(p.data) && strings.IndexByte(" \t", p.data[next]) >= 0 {
+				p.pos = next
+				p.skip(" \t")
+			}
+		}
+
+		entry := envEntry{name: p.name()}
+		if len(entry.name) == 0 {
+			return nil, p.errorf("expected variable name")
+		}
+
+		p.skip(" \t")
+		if p.pos == len(p.data) || p.data[p.pos] != '=' {
+			return nil, p.errorf("expected '=' after %s", entry.name)
+		}
+		p.pos++
+		p.skip(" \t")
+
+		if p.pos < len(p.data) && (p.data[p.pos] == '"' || p.data[p.pos] == '\'') {
+			var err error
+			entry.literal = p.data[p.pos] == '\''
+			if entry.value, err = p.quoted(p.data[p.pos]); err != nil {
+				return nil, err
+			}
+
+			p.skip(" \t")
+			if p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '#' {
+				return nil, p.errorf("unexpected characters after quoted value of %s", entry.name)
+			}
+			p.skipLine()
+		} else {
+			entry.value = p.unquoted()
+			p.skipLine()
+		}
+
+		entries = append(entries, entry)
+		p.pos++
+	}
+
+	return entries, nil
+}
+
+func (conf *config) taskDir(taskName string) string {
+	if origin, ok := conf.origins["tasks."+taskName]; ok {
+		return filepath.Dir(origin)
+	}
+
+	return conf.srcDir
+}
+
+func loadEnvFile(filename, baseDir string, conf *config) error {
+	optional := strings.HasPrefix(filename, "?")
+	filename, err := expand(strings.TrimPrefix(filename, "?"), conf)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(filename) && len(baseDir) > 0 {
+		filename = filepath.Join(baseDir, filename)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) && optional {
+		if conf.flags&flagVerbose != 0 {
+			log.Printf("skipping missing environment file: %s", filename)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	entries, err := parseEnvFile(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if conf.flags&flagVerbose != 0 {
+		log.Printf("loading environment file: %s", filename)
+	}
+	if conf.flags&flagDryRun != 0 {
+		plan("load environment file: %s", filename)
+	}
+
+	for _, entry := range entries {
+		value := entry.value
+		if !entry.literal {
+			if value, err = expand(value, conf); err != nil {
+				return fmt.Errorf("%s: %s: %w", filename, entry.name, err)
+			}
+		}
+
+		if conf.flags&flagVerbose != 0 {
+			log.Printf("setting variable %s to %s", entry.name, value)
+		}
+		if conf.flags&flagDryRun != 0 {
+			plan("set variable %s to %s", entry.name, value)
+		}
+		os.Setenv(entry.name, value)
+	}
+
+	return nil
+}
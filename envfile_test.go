@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		entries []envEntry
+		err     string
+	}{
+		{name: "empty", data: ""},
+		{name: "comments and blank lines", data: "# comment\n\n   \n\t# indented\n"},
+		{
+			name:    "plain",
+			data:    "FOO=bar\nBAZ = qux \n",
+			entries: []envEntry{{name: "FOO", value: "bar"}, {name: "BAZ", value: "qux"}},
+		},
+		{
+			name:    "empty value",
+			data:    "FOO=\nBAR=''\n",
+			entries: []envEntry{{name: "FOO"}, {name: "BAR", literal: true}},
+		},
+		{
+			name:    "no trailing newline",
+			data:    "FOO=bar",
+			entries: []envEntry{{name: "FOO", value: "bar"}},
+		},
+		{
+			name:    "crlf",
+			data:    "FOO=bar\r\nBAR=\"baz\"\r\n",
+			entries: []envEntry{{name: "FOO", value: "bar"}, {name: "BAR", value: "baz"}},
+		},
+		{
+			name:    "export prefix",
+			data:    "export FOO=bar\n  export\tBAR=baz\n",
+			entries: []envEntry{{name: "FOO", value: "bar"}, {name: "BAR", value: "baz"}},
+		},
+		{
+			name:    "export as name",
+			data:    "export=1\nexported=2\n",
+			entries: []envEntry{{name: "export", value: "1"}, {name: "exported", value: "2"}},
+		},
+		{
+			name: "inline comments",
+			data: "FOO=bar # comment\nBAR=a#b\nBAZ=\"x # y\" # comment\nQUX='z'#comment\n",
+			entries: []envEntry{
+				{name: "FOO", value: "bar"},
+				{name: "BAR", value: "a#b"},
+				{name: "BAZ", value: "x # y"},
+				{name: "QUX", value: "z", literal: true},
+			},
+		},
+		{
+			name:    "single quotes",
+			data:    `FOO='$HOME \n "x"'`,
+			entries: []envEntry{{name: "FOO", value: `$HOME \n "x"`, literal: true}},
+		},
+		{
+			name:    "double quote escapes",
+			data:    `FOO="a\nb\tc\rd \"e\" \\f \x"`,
+			entries: []envEntry{{name: "FOO", value: "a\nb\tc\rd \"e\" \\f \\x"}},
+		},
+		{
+			name: "dollar escapes",
+			data: `FOO="\$HOME"` + "\n" + `BAR="$HOME"` + "\n" + `BAZ=\$HOME` + "\n",
+			entries: []envEntry{
+				{name: "FOO", value: "$$HOME"},
+				{name: "BAR", value: "$HOME"},
+				{name: "BAZ", value: `\$HOME`},
+			},
+		},
+		{
+			name: "multi-line values",
+			data: "FOO=\"one\ntwo\"\nBAR='three\nfour'\nBAZ=\"five\\\nsix\"\n",
+			entries: []envEntry{
+				{name: "FOO", value: "one\ntwo"},
+				{name: "BAR", value: "three\nfour", literal: true},
+				{name: "BAZ", value: "fivesix"},
+			},
+		},
+		{name: "unterminated double quote", data: "FOO=bar\nBAR=\"baz\n\nQUX=1\n", err: "line 2: unterminated quoted value"},
+		{name: "unterminated single quote", data: "FOO='bar", err: "line 1: unterminated quoted value"},
+		{name: "escaped closing quote", data: `FOO="bar\"`, err: "line 1: unterminated quoted value"},
+		{
+			name: "line numbers after multi-line value",
+			data: "FOO=\"a\nb\"\nBAR\n",
+			err:  "line 3: expected '=' after BAR",
+		},
+		{name: "missing name", data: "=bar\n", err: "line 1: expected variable name"},
+		{name: "invalid name", data: "FOO-BAR=1\n", err: "line 1: expected '=' after FOO"},
+		{name: "trailing characters", data: "FOO=\"bar\" baz\n", err: "line 1: unexpected characters after quoted value of FOO"},
+	}
+
+	for _, test := range tests {
+		entries, err := parseEnvFile(test.data)
+		switch {
+		case len(test.err) > 0 && err == nil:
+			t.Errorf("%s: expected error %q, got %+v", test.name, test.err, entries)
+		case len(test.err) > 0 && err.Error() != test.err:
+			t.Errorf("%s: expected error %q, got %q", test.name, test.err, err)
+		case len(test.err) == 0 && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		case len(test.err) == 0 && !reflect.DeepEqual(entries, test.entries):
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.entries, entries)
+		}
+	}
+}
+
+func TestLoadEnvFileTaskDir(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"main.toml": "include = [\"sub/inc.toml\"]\n\n[tasks.main]\nenvfiles = [\"main.env\"]\n",
+		"main.env":  "HM_TEST_MAIN=main\n",
+	})
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"inc.toml": "[tasks.inc]\nenvfiles = [\"inc.env\"]\n",
+		"inc.env":  "HM_TEST_INC=inc\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "sub", name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf, err := newConfig([]string{filepath.Join(dir, "main.toml")}, mergeError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.srcDir = t.TempDir()
+
+	t.Setenv("HM_TEST_MAIN", "")
+	t.Setenv("HM_TEST_INC", "")
+
+	for _, tn := range []string{"main", "inc"} {
+		for _, envFile := range conf.Tasks[tn].EnvFiles {
+			if err := loadEnvFile(envFile, conf.taskDir(tn), conf); err != nil {
+				t.Fatalf("%s: %s", tn, err)
+			}
+		}
+	}
+
+	if value := os.Getenv("HM_TEST_MAIN"); value != "main" {
+		t.Errorf("expected HM_TEST_MAIN to be main, got %q", value)
+	}
+	if value := os.Getenv("HM_TEST_INC"); value != "inc" {
+		t.Errorf("expected HM_TEST_INC to be inc, got %q", value)
+	}
+}
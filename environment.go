@@ -26,11 +26,126 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
 )
 
+const (
+	envScopeGlobal = "global"
+	envScopeTask   = "task"
+)
+
+func (t *task) envScope(conf *config) string {
+	if len(t.EnvScope) > 0 {
+		return t.EnvScope
+	}
+
+	return conf.envScope
+}
+
+func environ() map[string]string {
+	env := make(map[string]string)
+	for _, i := range os.Environ() {
+		if sep := strings.Index(i, "="); sep > 0 {
+			env[i[:sep]] = i[sep+1:]
+		}
+	}
+
+	return env
+}
+
+func importEnv(t string, conf *config) map[string]string {
+	imported := make(map[string]string)
+	for _, dep := range conf.graph.taskClosure(t) {
+		var names []string
+		for name := range conf.exports[dep] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value := conf.exports[dep][name]
+			if conf.flags&flagVerbose != 0 {
+				log.Printf("importing variable %s from task %s", name, dep)
+			}
+			os.Setenv(name, value)
+			imported[name] = value
+		}
+	}
+
+	return imported
+}
+
+func restoreEnv(name string, saved map[string]string, conf *config) {
+	if value, ok := saved[name]; ok {
+		if conf.flags&flagVerbose != 0 {
+			log.Printf("restoring variable %s to %s", name, value)
+		}
+		os.Setenv(name, value)
+		return
+	}
+
+	if conf.flags&flagVerbose != 0 {
+		log.Printf("unsetting variable: %s", name)
+	}
+	os.Unsetenv(name)
+}
+
+func scopeEnv(tn string, t *task, conf *config) func() {
+	saved := environ()
+	imported := importEnv(tn, conf)
+
+	if t.envScope(conf) != envScopeTask {
+		return func() {
+			for name, value := range imported {
+				if curr, ok := os.LookupEnv(name); ok && curr == value {
+					restoreEnv(name, saved, conf)
+				}
+			}
+		}
+	}
+
+	return func() {
+		env := environ()
+
+		exports := make(map[string]string)
+		for _, name := range t.Exports {
+			if value, ok := env[name]; ok {
+				exports[name] = value
+			}
+		}
+		if conf.exports == nil {
+			conf.exports = make(map[string]map[string]string)
+		}
+		conf.exports[tn] = exports
+
+		var names []string
+		for name := range env {
+			names = append(names, name)
+		}
+		for name := range saved {
+			if _, ok := env[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value, ok := saved[name]
+			if curr, set := env[name]; ok && set && curr == value {
+				continue
+			}
+
+			restoreEnv(name, saved, conf)
+		}
+	}
+}
+
 func processEnv(env []string, conf *config) error {
-	args := appendExpEnv(nil, env)
+	args, err := appendExpanded(nil, env, conf)
+	if err != nil {
+		return err
+	}
 
 	var value string
 	switch {
@@ -40,12 +155,18 @@ func processEnv(env []string, conf *config) error {
 		if conf.flags&flagVerbose != 0 {
 			log.Printf("unsetting variable: %s", args[0])
 		}
+		if conf.flags&flagDryRun != 0 {
+			plan("unset variable: %s", args[0])
+		}
 		os.Unsetenv(args[0])
 		return nil
 	default:
 		if strings.HasPrefix(args[1], "!") {
-			var err error
 			args[1] = strings.TrimLeft(args[1], "!")
+			if conf.flags&flagDryRun != 0 {
+				plan("set variable %s from command: %s", args[0], strings.Join(args[1:], " "))
+				return nil
+			}
 			if value, err = processCmdWithReturn(args[1:], conf); err != nil {
 				return err
 			}
@@ -57,6 +178,9 @@ func processEnv(env []string, conf *config) error {
 	if conf.flags&flagVerbose != 0 {
 		log.Printf("setting variable %s to %s", args[0], value)
 	}
+	if conf.flags&flagDryRun != 0 {
+		plan("set variable %s to %s", args[0], value)
+	}
 
 	os.Setenv(args[0], value)
 	return nil
@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type expander struct {
+	vars   map[string]interface{}
+	strict bool
+	seen   func(name string)
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func matchBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '$':
+			i++
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func (e *expander) lookup(name string) (string, bool) {
+	if e.seen != nil {
+		e.seen(name)
+	}
+
+	if value, ok := lookupVar(e.vars, name); ok {
+		return formatVar(value), true
+	}
+
+	return os.LookupEnv(name)
+}
+
+func (e *expander) reference(ref string) (string, error) {
+	name, op, word := ref, "", ""
+	if i := strings.Index(ref, ":"); i >= 0 && i+1 < len(ref) && (ref[i+1] == '-' || ref[i+1] == '?') {
+		name, op, word = ref[:i], ref[i:i+2], ref[i+2:]
+	}
+
+	if len(name) == 0 {
+		return "", fmt.Errorf("bad variable reference: ${%s}", ref)
+	}
+
+	value, ok := e.lookup(name)
+	switch {
+	case op == ":-" && len(value) == 0:
+		return e.expand(word)
+	case op == ":?" && len(value) == 0:
+		msg, err := e.expand(word)
+		if err != nil {
+			return "", err
+		}
+		if len(msg) == 0 {
+			msg = "variable is empty or not set"
+		}
+		return "", fmt.Errorf("%s: %s", name, msg)
+	case !ok && e.strict && len(op) == 0:
+		return "", fmt.Errorf("undefined variable: %s", name)
+	}
+
+	return value, nil
+}
+
+func (e *expander) expand(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		var ref string
+		switch c := s[i+1]; {
+		case c == '$':
+			b.WriteByte('$')
+			i++
+			continue
+		case c == '{':
+			end := matchBrace(s, i+2)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference: %s", s[i:])
+			}
+			ref, i = s[i+2:end], end
+		case c >= '0' && c <= '9':
+			ref, i = s[i+1:i+2], i+1
+		case isNameByte(c):
+			end := i + 1
+			for end < len(s) && isNameByte(s[end]) {
+				end++
+			}
+			ref, i = s[i+1:end], end-1
+		default:
+			b.WriteByte('$')
+			continue
+		}
+
+		value, err := e.reference(ref)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+	}
+
+	return b.String(), nil
+}
+
+func expandEnv(s string) (string, error) {
+	e := &expander{}
+	return e.expand(s)
+}
+
+func referencedNames(s string) []string {
+	var names []string
+	e := &expander{seen: func(name string) { names = append(names, name) }}
+	e.expand(s)
+
+	return names
+}
+
+func expand(s string, conf *config) (string, error) {
+	e := &expander{vars: conf.vars(), strict: conf.flags&flagStrictEnv != 0}
+	return e.expand(s)
+}
+
+func appendExpanded(dst, src []string, conf *config) ([]string, error) {
+	for _, value := range src {
+		expanded, err := expand(value, conf)
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, expanded)
+	}
+
+	return dst, nil
+}
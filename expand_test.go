@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	t.Setenv("HM_TEST_ENV", "env")
+	t.Setenv("HM_TEST_EMPTY", "")
+
+	vars := map[string]interface{}{
+		"name":  "value",
+		"empty": "",
+		"num":   42,
+		"1":     "first",
+		"dir":   "$HM_TEST_ENV",
+		"nested": map[string]interface{}{
+			"key": "inner",
+		},
+	}
+
+	tests := []struct {
+		input  string
+		output string
+		strict bool
+		err    string
+	}{
+		{input: "plain", output: "plain"},
+		{input: "$name", output: "value"},
+		{input: "${name}", output: "value"},
+		{input: "a${name}b", output: "avalueb"},
+		{input: "$name.txt", output: "value.txt"},
+		{input: "$num", output: "42"},
+		{input: "${nested.key}", output: "inner"},
+		{input: "$HM_TEST_ENV", output: "env"},
+		{input: "$missing", output: ""},
+		{input: "$dir", output: "$HM_TEST_ENV"},
+
+		{input: "${missing:-fallback}", output: "fallback"},
+		{input: "${empty:-fallback}", output: "fallback"},
+		{input: "${HM_TEST_EMPTY:-fallback}", output: "fallback"},
+		{input: "${name:-fallback}", output: "value"},
+		{input: "${missing:-}", output: ""},
+		{input: "${missing-x}", output: ""},
+
+		{input: "${name:?not set}", output: "value"},
+		{input: "${missing:?not set}", err: "missing: not set"},
+		{input: "${empty:?}", err: "empty: variable is empty or not set"},
+		{input: "${missing:?$name is required}", err: "missing: value is required"},
+
+		{input: "$$", output: "$"},
+		{input: "$$name", output: "$name"},
+		{input: "$${name}", output: "${name}"},
+		{input: "$$$name", output: "$value"},
+		{input: "cost: 5$", output: "cost: 5$"},
+		{input: "$-", output: "$-"},
+		{input: "$ name", output: "$ name"},
+
+		{input: "${missing:-${name}}", output: "value"},
+		{input: "${missing:-$name}", output: "value"},
+		{input: "${missing:-${other:-deep}}", output: "deep"},
+		{input: "${missing:-a}b}", output: "ab}"},
+		{input: "${missing:-$${name}}", output: "${name}"},
+		{input: "${missing:?${other:-why}}", err: "missing: why"},
+
+		{input: "${name", err: "unterminated variable reference: ${name"},
+		{input: "x${missing:-${name}", err: "unterminated variable reference: ${missing:-${name}"},
+		{input: "${}", err: "bad variable reference: ${}"},
+		{input: "${:-x}", err: "bad variable reference: ${:-x}"},
+
+		{input: "$1", output: "first"},
+		{input: "$10", output: "first0"},
+		{input: "${1}", output: "first"},
+		{input: "$2", output: ""},
+
+		{input: "$missing", strict: true, err: "undefined variable: missing"},
+		{input: "${missing}", strict: true, err: "undefined variable: missing"},
+		{input: "$2", strict: true, err: "undefined variable: 2"},
+		{input: "${missing:-fallback}", strict: true, output: "fallback"},
+		{input: "$empty", strict: true, output: ""},
+		{input: "$HM_TEST_EMPTY", strict: true, output: ""},
+		{input: "$$missing", strict: true, output: "$missing"},
+		{input: "${missing:-$other}", strict: true, err: "undefined variable: other"},
+	}
+
+	for _, test := range tests {
+		e := &expander{vars: vars, strict: test.strict}
+		output, err := e.expand(test.input)
+
+		switch {
+		case len(test.err) > 0 && err == nil:
+			t.Errorf("%q (strict %t): expected error %q, got %q", test.input, test.strict, test.err, output)
+		case len(test.err) > 0 && err.Error() != test.err:
+			t.Errorf("%q (strict %t): expected error %q, got %q", test.input, test.strict, test.err, err)
+		case len(test.err) == 0 && err != nil:
+			t.Errorf("%q (strict %t): unexpected error: %s", test.input, test.strict, err)
+		case len(test.err) == 0 && output != test.output:
+			t.Errorf("%q (strict %t): expected %q, got %q", test.input, test.strict, test.output, output)
+		}
+	}
+}
+
+func TestReferencedNames(t *testing.T) {
+	tests := []struct {
+		input string
+		names []string
+	}{
+		{input: "plain", names: nil},
+		{input: "$a/${b}", names: []string{"a", "b"}},
+		{input: "$$a", names: nil},
+		{input: "${a:-$b}", names: []string{"a", "b"}},
+		{input: "${a:-x}", names: []string{"a"}},
+	}
+
+	for _, test := range tests {
+		if names := referencedNames(test.input); !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%q: expected %v, got %v", test.input, test.names, names)
+		}
+	}
+}
@@ -5,6 +5,7 @@ go 1.18
 require (
 	github.com/naoina/toml v0.1.1
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
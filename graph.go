@@ -0,0 +1,433 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type graphNode struct {
+	name  string
+	macro bool
+	deps  []string
+}
+
+type taskGraph struct {
+	nodes map[string]*graphNode
+	roots []string
+	order []string
+}
+
+func resolveTask(taskName string, conf *config) (string, *task) {
+	for _, tn := range makeVariantNames(taskName, conf.variant) {
+		if t, ok := conf.Tasks[tn]; ok {
+			return tn, &t
+		}
+	}
+
+	return "", nil
+}
+
+func selectTagged(tags []string, conf *config) []string {
+	bases := make(map[string]bool)
+	for tn := range conf.Tasks {
+		if nameParts := strings.Split(tn, "__"); len(nameParts) > 1 {
+			tn = strings.Join(nameParts[:len(nameParts)-1], "__")
+		}
+		bases[tn] = true
+	}
+
+	var taskNames []string
+	for base := range bases {
+		if _, t := resolveTask(base, conf); t != nil && t.tagged(tags) {
+			taskNames = append(taskNames, base)
+		}
+	}
+	sort.Strings(taskNames)
+
+	return taskNames
+}
+
+func buildGraph(taskNames []string, conf *config) (*taskGraph, error) {
+	g := &taskGraph{nodes: make(map[string]*graphNode)}
+	visiting := make(map[string]bool)
+
+	for _, taskName := range taskNames {
+		tn, err := g.addTask(taskName, nil, visiting, conf)
+		if err != nil {
+			return nil, err
+		}
+
+		if !containsString(g.roots, tn) {
+			g.roots = append(g.roots, tn)
+		}
+	}
+
+	return g, nil
+}
+
+func (g *taskGraph) enter(key string, path []string, visiting map[string]bool) (bool, error) {
+	if visiting[key] {
+		for i, k := range path {
+			if k == key {
+				return false, fmt.Errorf("dependency cycle detected: %s", strings.Join(append(path[i:], key), " -> "))
+			}
+		}
+	}
+
+	_, ok := g.nodes[key]
+	return !ok, nil
+}
+
+func (g *taskGraph) addTask(taskName string, path []string, visiting map[string]bool, conf *config) (string, error) {
+	tn, t := resolveTask(taskName, conf)
+	if t == nil {
+		if len(path) > 0 {
+			return "", fmt.Errorf("task or variant not found: %s (required by %s)", taskName, path[len(path)-1])
+		}
+		return "", fmt.Errorf("task or variant not found: %s", taskName)
+	}
+
+	if enter, err := g.enter(tn, path, visiting); err != nil || !enter {
+		return tn, err
+	}
+
+	path = append(path, tn)
+	visiting[tn] = true
+	node := &graphNode{name: tn}
+
+	prevTask := conf.task
+	conf.task = tn
+	defer func() { conf.task = prevTask }()
+
+	if err := conf.resolvePrompts(t.Vars, tn+"/", ""); err != nil {
+		return "", err
+	}
+
+	for _, currTask := range t.Deps {
+		currTask, err := expand(currTask, conf)
+		if err != nil {
+			return "", err
+		}
+		dep, err := g.addTask(currTask, path, visiting, conf)
+		if err != nil {
+			return "", err
+		}
+		node.deps = append(node.deps, dep)
+	}
+
+	if conf.flags&flagNoCmds == 0 {
+		cmds := append(append(append([][]string(nil), t.CmdsPre...), t.Cmds...), t.CmdsPost...)
+		deps, err := g.addCmdMacros(cmds, path, visiting, conf)
+		if err != nil {
+			return "", err
+		}
+		node.deps = append(node.deps, deps...)
+	}
+
+	visiting[tn] = false
+	g.nodes[tn] = node
+	g.order = append(g.order, tn)
+	return tn, nil
+}
+
+func (g *taskGraph) addCmdMacros(cmds [][]string, path []string, visiting map[string]bool, conf *config) ([]string, error) {
+	var deps []string
+	for _, currCmd := range cmds {
+		if len(currCmd) == 0 {
+			continue
+		}
+
+		macroName, err := expand(currCmd[0], conf)
+		if err != nil {
+			return nil, err
+		}
+
+		m, mn := findCmdMacro(macroName, conf)
+		if m == nil {
+			continue
+		}
+
+		dep, err := g.addMacro(mn, m, path, visiting, conf)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
+	}
+
+	return deps, nil
+}
+
+func (g *taskGraph) addMacro(macroName string, m *macro, path []string, visiting map[string]bool, conf *config) (string, error) {
+	key := "@" + macroName
+	if enter, err := g.enter(key, path, visiting); err != nil || !enter {
+		return key, err
+	}
+
+	path = append(path, key)
+	visiting[key] = true
+	node := &graphNode{name: macroName, macro: true}
+
+	for _, currTask := range m.Deps {
+		currTask, err := expand(currTask, conf)
+		if err != nil {
+			return "", err
+		}
+		dep, err := g.addTask(currTask, path, visiting, conf)
+		if err != nil {
+			return "", err
+		}
+		node.deps = append(node.deps, dep)
+	}
+
+	deps, err := g.addCmdMacros([][]string{m.Prefix}, path, visiting, conf)
+	if err != nil {
+		return "", err
+	}
+	node.deps = append(node.deps, deps...)
+
+	visiting[key] = false
+	g.nodes[key] = node
+	g.order = append(g.order, key)
+	return key, nil
+}
+
+func (g *taskGraph) tagged(tags []string, conf *config) []string {
+	var taskNames []string
+	for _, key := range g.order {
+		if t, ok := conf.Tasks[key]; ok && !g.nodes[key].macro && t.tagged(tags) {
+			taskNames = append(taskNames, key)
+		}
+	}
+
+	return taskNames
+}
+
+func (g *taskGraph) skip(taskNames []string, exclusive bool, conf *config) error {
+	skipped := make(map[string]bool)
+	for _, taskName := range taskNames {
+		tn, t := resolveTask(taskName, conf)
+		if t == nil {
+			return fmt.Errorf("task or variant not found: %s", taskName)
+		}
+		if g.nodes[tn] != nil {
+			skipped[tn] = true
+		}
+	}
+
+	if exclusive {
+		reachable := make(map[string]bool)
+		var visit func(key string)
+		visit = func(key string) {
+			if reachable[key] || skipped[key] {
+				return
+			}
+			reachable[key] = true
+			for _, dep := range g.nodes[key].deps {
+				visit(dep)
+			}
+		}
+
+		for _, root := range g.roots {
+			visit(root)
+		}
+
+		for key := range g.nodes {
+			if !reachable[key] {
+				skipped[key] = true
+			}
+		}
+	}
+
+	var splice func(deps, result []string) []string
+	splice = func(deps, result []string) []string {
+		for _, dep := range deps {
+			switch {
+			case skipped[dep] && !exclusive:
+				result = splice(g.nodes[dep].deps, result)
+			case !skipped[dep] && !containsString(result, dep):
+				result = append(result, dep)
+			}
+		}
+		return result
+	}
+
+	for key, node := range g.nodes {
+		if !skipped[key] {
+			node.deps = splice(node.deps, nil)
+		}
+	}
+
+	roots := splice(g.roots, nil)
+
+	var order []string
+	for _, key := range g.order {
+		if !skipped[key] {
+			order = append(order, key)
+		}
+	}
+	for key := range skipped {
+		delete(g.nodes, key)
+	}
+
+	if conf.flags&flagVerbose != 0 && len(skipped) > 0 {
+		var keys []string
+		for _, key := range g.order {
+			if skipped[key] {
+				keys = append(keys, key)
+			}
+		}
+		log.Printf("skipping tasks: %s", strings.Join(keys, ", "))
+	}
+
+	g.roots, g.order = roots, order
+	return nil
+}
+
+func (g *taskGraph) taskDeps(key string) []string {
+	var deps []string
+	for _, dep := range g.nodes[key].deps {
+		if g.nodes[dep].macro {
+			deps = append(deps, g.taskDeps(dep)...)
+		} else {
+			deps = append(deps, dep)
+		}
+	}
+
+	return deps
+}
+
+func (g *taskGraph) taskClosure(key string) []string {
+	var deps []string
+	seen := make(map[string]bool)
+
+	var visit func(key string)
+	visit = func(key string) {
+		for _, dep := range g.taskDeps(key) {
+			if !seen[dep] {
+				seen[dep] = true
+				visit(dep)
+				deps = append(deps, dep)
+			}
+		}
+	}
+	visit(key)
+
+	return deps
+}
+
+func (g *taskGraph) tasks() []string {
+	var tasks []string
+	for _, key := range g.order {
+		if !g.nodes[key].macro {
+			tasks = append(tasks, key)
+		}
+	}
+
+	return tasks
+}
+
+func (g *taskGraph) label(key string, annotate bool) string {
+	node := g.nodes[key]
+
+	name, variant := node.name, ""
+	if nameParts := strings.Split(name, "__"); len(nameParts) > 1 {
+		name = strings.Join(nameParts[:len(nameParts)-1], "__")
+		variant = nameParts[len(nameParts)-1]
+	}
+
+	if node.macro {
+		name = "@" + name
+	}
+
+	if !annotate {
+		return name
+	}
+	if len(variant) == 0 {
+		return name + " (base)"
+	}
+
+	return fmt.Sprintf("%s (%s)", name, variant)
+}
+
+func (g *taskGraph) writeDot(w io.Writer, annotate bool) {
+	fmt.Fprintln(w, "digraph homemaker {")
+
+	for _, key := range g.order {
+		attrs := "label=" + strconv.Quote(g.label(key, annotate))
+		if g.nodes[key].macro {
+			attrs += ", shape=box"
+		}
+
+		fmt.Fprintf(w, "    %s [%s];\n", strconv.Quote(key), attrs)
+	}
+
+	for _, key := range g.order {
+		for _, dep := range g.nodes[key].deps {
+			fmt.Fprintf(w, "    %s -> %s;\n", strconv.Quote(key), strconv.Quote(dep))
+		}
+	}
+
+	fmt.Fprintln(w, "}")
+}
+
+func (g *taskGraph) writeMermaid(w io.Writer, annotate bool) {
+	fmt.Fprintln(w, "graph TD")
+
+	ids := make(map[string]string)
+	for i, key := range g.order {
+		ids[key] = fmt.Sprintf("n%d", i)
+
+		label := strings.ReplaceAll(g.label(key, annotate), "\"", "#quot;")
+		if g.nodes[key].macro {
+			fmt.Fprintf(w, "    %s[[\"%s\"]]\n", ids[key], label)
+		} else {
+			fmt.Fprintf(w, "    %s[\"%s\"]\n", ids[key], label)
+		}
+	}
+
+	for _, key := range g.order {
+		for _, dep := range g.nodes[key].deps {
+			fmt.Fprintf(w, "    %s --> %s\n", ids[key], ids[dep])
+		}
+	}
+}
+
+func printGraph(format string, annotate bool, conf *config) error {
+	switch format {
+	case "", "dot":
+		conf.graph.writeDot(os.Stdout, annotate)
+	case "mermaid":
+		conf.graph.writeMermaid(os.Stdout, annotate)
+	default:
+		return fmt.Errorf("unsupported graph format: %s", format)
+	}
+
+	return nil
+}
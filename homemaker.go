@@ -39,82 +39,650 @@ const (
 	flagNoLinks
 	flagNoTemplates
 	flagNoMacro
+	flagDryRun
+	flagPrune
+	flagBackup
+	flagKeepGoing
+	flagStrictEnv
+	flagNoPrompt
+	flagAllowUnknown
 	flagUnlink = flagNoCmds | (1 << iota)
 )
 
-func usage() {
-	fmt.Fprintf(os.Stderr, "Usage: %s [options] conf src\n", filepath.Base(os.Args[0]))
-	fmt.Fprintf(os.Stderr, "https://foosoft.net/projects/homemaker/\n\n")
-	fmt.Fprintf(os.Stderr, "Parameters:\n")
-	flag.PrintDefaults()
+type options struct {
+	confFiles    stringList
+	vars         stringList
+	varFiles     stringList
+	envFiles     stringList
+	merge        string
+	taskName     string
+	skip         string
+	skipDeps     bool
+	tags         string
+	skipTags     string
+	dstDir       string
+	variant      string
+	envScope     string
+	onError      string
+	answer       string
+	answersFile  string
+	format       string
+	force        bool
+	clobber      bool
+	verbose      bool
+	nocmds       bool
+	nolinks      bool
+	notemplates  bool
+	unlink       bool
+	all          bool
+	annotate     bool
+	dryrun       bool
+	keepGoing    bool
+	backup       bool
+	prune        bool
+	strictEnv    bool
+	allowUnknown bool
 }
 
-func main() {
-	taskName := flag.String("task", "default", "name of task to execute")
-	dstDir := flag.String("dest", "", "target directory for tasks")
-	force := flag.Bool("force", true, "create parent directories to target")
-	clobber := flag.Bool("clobber", false, "delete files and directories at target")
-	verbose := flag.Bool("verbose", false, "verbose output")
-	nocmds := flag.Bool("nocmds", false, "don't execute commands")
-	nolinks := flag.Bool("nolinks", false, "don't create links")
-	notemplates := flag.Bool("notemplates", false, "don't process templates")
-	variant := flag.String("variant", "", "execution variant for tasks and macros")
-	unlink := flag.Bool("unlink", false, "remove existing links instead of creating them")
+type command struct {
+	name    string
+	args    string
+	usage   string
+	options []string
+}
 
-	flag.Usage = usage
-	flag.Parse()
+var (
+	configOptions = []string{"config", "merge", "allowunknown", "verbose"}
+	selectOptions = []string{"task", "tags", "skip", "skiptags", "skipdeps"}
+	varOptions    = []string{"var", "varfile", "envfile", "strictenv", "envscope"}
+	taskOptions   = joinOptions(joinOptions(joinOptions(configOptions, selectOptions...), varOptions...), "variant", "dest")
+	applyOptions  = joinOptions(taskOptions, "force", "clobber", "nocmds", "nolinks", "notemplates", "unlink",
+		"answer", "answers", "backup", "prune")
+)
+
+var commands = []command{
+	{"apply", "[conf] [src]", "process the selected task (default)",
+		joinOptions(applyOptions, "onerror", "dryrun", "keepgoing")},
+	{"completion", "bash|zsh|fish", "print a shell completion script", nil},
+	{"config", "show [conf] [src]", "print the effective configuration after merging all files",
+		joinOptions(configOptions, "format")},
+	{"convert", "[conf]", "rewrite a configuration file in the format given by -format (or reformat it in its own format)",
+		[]string{"config", "format"}},
+	{"graph", "[conf] [src]", "print the dependency graph of the selected task as DOT or Mermaid",
+		joinOptions(taskOptions, "nocmds", "format", "annotate")},
+	{"help", "[command]", "show help for a command", nil},
+	{"list", "[conf] [src]", "list tasks and macros with their variants, descriptions and dependencies",
+		joinOptions(configOptions, "format", "all")},
+	{"plan", "[conf] [src]", "print the actions apply would perform without executing them", applyOptions},
+	{"restore", "[conf] [src]", "put backed up paths back in place of links and templates",
+		joinOptions(configOptions, "dest", "dryrun")},
+	{"schema", "", "print a JSON Schema describing the configuration file format", nil},
+	{"status", "[conf] [src]", "report links and templates out of sync with the destination", taskOptions},
+	{"unlink", "[conf] [src]", "remove the links created by the selected task",
+		joinOptions(taskOptions, "nocmds", "onerror", "dryrun", "keepgoing")},
+	{"validate", "[conf]", "check the configuration for unknown keys, malformed entries and missing references",
+		joinOptions(configOptions, "variant")},
+	{"vars", "list|reset [conf] [name...]", "list or forget the answers to prompted variables stored on this machine",
+		joinOptions(configOptions, "format")},
+}
+
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
 
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func joinOptions(base []string, names ...string) []string {
+	return append(append([]string(nil), base...), names...)
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+
+	return nil
+}
+
+func (o *options) register(fs *flag.FlagSet, names []string) {
+	for _, name := range names {
+		switch name {
+		case "config":
+			fs.Var(&o.confFiles, "config", "configuration file to load (can be repeated)")
+		case "var":
+			fs.Var(&o.vars, "var", "set a variable as name=value, overriding the configuration (can be repeated)")
+		case "varfile":
+			fs.Var(&o.varFiles, "varfile", "file with variables overriding the configuration (can be repeated)")
+		case "envfile":
+			fs.Var(&o.envFiles, "envfile", "dotenv file to load before processing tasks, optional if prefixed with ? (can be repeated)")
+		case "merge":
+			fs.StringVar(&o.merge, "merge", "error", "handling of tasks and macros defined in several files: error, override or append")
+		case "task":
+			fs.StringVar(&o.taskName, "task", "default", "names of tasks to execute, separated by commas")
+		case "skip":
+			fs.StringVar(&o.skip, "skip", "", "names of tasks to exclude, separated by commas")
+		case "tags":
+			fs.StringVar(&o.tags, "tags", "", "select tasks with any of these tags, separated by commas")
+		case "skiptags":
+			fs.StringVar(&o.skipTags, "skiptags", "", "exclude tasks with any of these tags, separated by commas")
+		case "skipdeps":
+			fs.BoolVar(&o.skipDeps, "skipdeps", false, "also exclude dependencies only required by skipped tasks")
+		case "dest":
+			fs.StringVar(&o.dstDir, "dest", "", "target directory for tasks")
+		case "force":
+			fs.BoolVar(&o.force, "force", true, "create parent directories to target")
+		case "clobber":
+			fs.BoolVar(&o.clobber, "clobber", false, "delete files and directories at target")
+		case "verbose":
+			fs.BoolVar(&o.verbose, "verbose", false, "verbose output")
+		case "nocmds":
+			fs.BoolVar(&o.nocmds, "nocmds", false, "don't execute commands")
+		case "nolinks":
+			fs.BoolVar(&o.nolinks, "nolinks", false, "don't create links")
+		case "notemplates":
+			fs.BoolVar(&o.notemplates, "notemplates", false, "don't process templates")
+		case "variant":
+			fs.StringVar(&o.variant, "variant", "", "execution variant for tasks and macros")
+		case "envscope":
+			fs.StringVar(&o.envScope, "envscope", "", "default scope of environment variables set by tasks: global or task")
+		case "unlink":
+			fs.BoolVar(&o.unlink, "unlink", false, "remove existing links instead of creating them")
+		case "onerror":
+			fs.StringVar(&o.onError, "onerror", "prompt", "error policy: prompt, abort, skip or retry:N")
+		case "answer":
+			fs.StringVar(&o.answer, "answer", "prompt", "answer to clobber and create prompts: prompt, yes or no")
+		case "answers":
+			fs.StringVar(&o.answersFile, "answers", "", "file with answers to prompts for specific paths")
+		case "format":
+			fs.StringVar(&o.format, "format", "", "output format for commands which support several")
+		case "all":
+			fs.BoolVar(&o.all, "all", false, "include hidden tasks and macros when listing")
+		case "annotate":
+			fs.BoolVar(&o.annotate, "annotate", false, "annotate graph nodes with their resolved variants")
+		case "dryrun":
+			fs.BoolVar(&o.dryrun, "dryrun", false, "print planned actions without executing them")
+		case "keepgoing":
+			fs.BoolVar(&o.keepGoing, "keepgoing", false, "continue with independent tasks after failures")
+		case "backup":
+			fs.BoolVar(&o.backup, "backup", false, "move clobbered files and directories into a backup tree")
+		case "strictenv":
+			fs.BoolVar(&o.strictEnv, "strictenv", false, "fail on references to undefined variables")
+		case "allowunknown":
+			fs.BoolVar(&o.allowUnknown, "allowunknown", false, "warn about unknown configuration keys instead of failing")
+		case "prune":
+			fs.BoolVar(&o.prune, "prune", false, "remove previously created links no longer in the configuration")
+		}
+	}
+}
+
+func (o *options) flags() int {
 	flags := 0
-	if *clobber {
+	if o.clobber {
 		flags |= flagClobber
 	}
-	if *force {
+	if o.force {
 		flags |= flagForce
 	}
-	if *verbose {
+	if o.verbose {
 		flags |= flagVerbose
 	}
-	if *nocmds {
+	if o.nocmds {
 		flags |= flagNoCmds
 	}
-	if *nolinks {
+	if o.nolinks {
 		flags |= flagNoLinks
 	}
-	if *notemplates {
+	if o.notemplates {
 		flags |= flagNoTemplates
 	}
-	if *unlink {
+	if o.unlink {
 		flags |= flagUnlink
 	}
+	if o.dryrun {
+		flags |= flagDryRun
+	}
+	if o.prune {
+		flags |= flagPrune
+	}
+	if o.backup {
+		flags |= flagBackup
+	}
+	if o.keepGoing {
+		flags |= flagKeepGoing
+	}
+	if o.strictEnv {
+		flags |= flagStrictEnv
+	}
+	if o.allowUnknown {
+		flags |= flagAllowUnknown
+	}
 
-	if flag.NArg() == 2 {
-		confFile := makeAbsPath(flag.Arg(0))
+	return flags
+}
 
-		conf, err := newConfig(confFile)
-		if err != nil {
+func isFlagSet(name string, flagSets ...*flag.FlagSet) bool {
+	found := false
+	for _, fs := range flagSets {
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == name {
+				found = true
+			}
+		})
+	}
+
+	return found
+}
+
+func mergeFlags(dst, src *flag.FlagSet) {
+	src.Visit(func(f *flag.Flag) {
+		if items, ok := f.Value.(*stringList); ok {
+			for _, item := range *items {
+				dst.Set(f.Name, item)
+			}
+			return
+		}
+
+		dst.Set(f.Name, f.Value.String())
+	})
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [command] [conf] [src]\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "https://foosoft.net/projects/homemaker/\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n    \t%s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s help command' for the parameters of each command.\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "\nParameters:\n")
+	flag.PrintDefaults()
+}
+
+func commandUsage(c *command, fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [options] %s\n", filepath.Base(os.Args[0]), c.name, c.args)
+		fmt.Fprintf(os.Stderr, "%s\n", c.usage)
+		if len(c.options) > 0 {
+			fmt.Fprintf(os.Stderr, "\nParameters:\n")
+			fs.PrintDefaults()
+		}
+	}
+}
+
+func reportFailures(conf *config) {
+	fmt.Fprintf(os.Stderr, "%d task(s) failed:\n", len(conf.failures))
+	for _, f := range conf.failures {
+		if len(f.entry) > 0 {
+			fmt.Fprintf(os.Stderr, "  %s: %s: %s\n", f.task, f.entry, f.err)
+		} else {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", f.task, f.err)
+		}
+	}
+}
+
+func main() {
+	opts := new(options)
+
+	var allOptions []string
+	registered := make(map[string]bool)
+	for _, c := range commands {
+		for _, name := range c.options {
+			if !registered[name] {
+				allOptions = append(allOptions, name)
+				registered[name] = true
+			}
+		}
+	}
+	opts.register(flag.CommandLine, allOptions)
+
+	flagSets := make(map[string]*flag.FlagSet)
+	for i := range commands {
+		c := &commands[i]
+		fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+		new(options).register(fs, c.options)
+		fs.Usage = commandUsage(c, fs)
+		flagSets[c.name] = fs
+	}
+
+	var taskArgs []string
+	cmdArgs := os.Args[1:]
+	for i, arg := range cmdArgs {
+		if arg == "--" {
+			cmdArgs, taskArgs = cmdArgs[:i], cmdArgs[i+1:]
+			break
+		}
+	}
+
+	flag.Usage = usage
+	flag.CommandLine.Parse(cmdArgs)
+
+	args := flag.Args()
+	if len(args) > 1 && args[0] == "__complete" {
+		printCompletionNames(args[1], args[2:])
+		return
+	}
+
+	command := "apply"
+	if len(args) > 0 && findCommand(args[0]) != nil {
+		command = args[0]
+		flagSets[command].Parse(args[1:])
+		args = flagSets[command].Args()
+	}
+
+	if command == "config" {
+		if len(args) == 0 || args[0] != "show" {
+			flagSets[command].Usage()
+			os.Exit(2)
+		}
+		flagSets[command].Parse(args[1:])
+		args = flagSets[command].Args()
+	}
+
+	var varsAction string
+	var varNames []string
+	if command == "vars" {
+		if len(args) == 0 || args[0] != "list" && args[0] != "reset" {
+			flagSets[command].Usage()
+			os.Exit(2)
+		}
+		varsAction = args[0]
+		flagSets[command].Parse(args[1:])
+		args = flagSets[command].Args()
+	}
+
+	mergeFlags(flag.CommandLine, flagSets[command])
+
+	if command == "vars" {
+		if len(opts.confFiles) == 0 && len(args) > 0 && isConfigFile(args[0]) {
+			opts.confFiles, args = args[:1], args[1:]
+		}
+		varNames, args = args, nil
+	}
+
+	flags := opts.flags()
+	switch command {
+	case "plan":
+		flags |= flagDryRun
+	case "graph", "status":
+		flags |= flagNoPrompt
+	case "unlink":
+		flags |= flagUnlink | flagNoTemplates
+	}
+
+	confFiles := opts.confFiles
+
+	if command == "help" {
+		if len(args) == 0 {
+			usage()
+			return
+		}
+		if c := findCommand(args[0]); c != nil {
+			flagSets[c.name].Usage()
+			return
+		}
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+		os.Exit(2)
+	}
+
+	if command == "completion" {
+		if len(args) != 1 {
+			flagSets[command].Usage()
+			os.Exit(2)
+		}
+		if err := printCompletion(args[0]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if command == "schema" {
+		if err := printSchema(); err != nil {
 			log.Fatal(err)
 		}
+		return
+	}
+
+	if command == "convert" {
+		if len(confFiles) == 0 && len(args) > 0 {
+			confFiles, args = args[:1], args[1:]
+		}
+		if len(confFiles) == 0 {
+			confFile, err := findConfig()
+			if err != nil {
+				log.Fatal(err)
+			}
+			confFiles = []string{confFile}
+		}
+		if len(confFiles) != 1 || len(args) != 0 {
+			flagSets[command].Usage()
+			os.Exit(2)
+		}
 
-		if strings.TrimSpace(*dstDir) == "" {
-			*dstDir, _ = os.UserHomeDir()
+		bytes, err := convertConfig(makeAbsPath(confFiles[0]), opts.format)
+		if err != nil {
+			log.Fatal(err)
 		}
 
-		conf.srcDir = makeAbsPath(flag.Arg(1))
-		conf.dstDir = makeAbsPath(*dstDir)
-		conf.variant = *variant
-		conf.flags = flags
+		os.Stdout.Write(bytes)
+		return
+	}
 
-		os.Setenv("HM_CONFIG", confFile)
-		os.Setenv("HM_TASK", *taskName)
-		os.Setenv("HM_SRC", conf.srcDir)
-		os.Setenv("HM_DEST", conf.dstDir)
-		os.Setenv("HM_VARIANT", conf.variant)
+	if len(confFiles) == 0 && (len(args) > 1 || len(args) == 1 && isConfigFile(args[0])) {
+		confFiles, args = args[:1], args[1:]
+	}
 
-		if err := processTask(*taskName, conf); err != nil {
+	if len(confFiles) == 0 {
+		confFile, err := findConfig()
+		if err != nil {
 			log.Fatal(err)
 		}
-	} else {
-		usage()
+		if flags&flagVerbose != 0 {
+			log.Printf("using configuration file: %s", confFile)
+		}
+		confFiles = []string{confFile}
+	}
+
+	if len(args) > 1 {
+		flagSets[command].Usage()
 		os.Exit(2)
 	}
+
+	for i, confFile := range confFiles {
+		confFiles[i] = makeAbsPath(confFile)
+	}
+
+	confFile := confFiles[0]
+
+	conf, err := newConfig(confFiles, opts.merge)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if strings.TrimSpace(opts.dstDir) == "" {
+		opts.dstDir, _ = os.UserHomeDir()
+	}
+
+	switch {
+	case len(args) > 0:
+		conf.srcDir = makeAbsPath(args[0])
+	case len(conf.Src) > 0:
+		conf.srcDir = conf.Src
+	default:
+		conf.srcDir = filepath.Dir(confFile)
+	}
+	conf.dstDir = makeAbsPath(opts.dstDir)
+	conf.variant = opts.variant
+	if len(conf.variant) == 0 {
+		conf.variant = conf.Variant
+	}
+	conf.envScope = opts.envScope
+	if len(conf.envScope) == 0 {
+		conf.envScope = conf.EnvScope
+	}
+	if len(conf.envScope) == 0 {
+		conf.envScope = envScopeGlobal
+	}
+	if conf.envScope != envScopeGlobal && conf.envScope != envScopeTask {
+		log.Fatalf("invalid environment scope: %s", conf.envScope)
+	}
+	conf.flags = flags
+
+	if conf.overrides, err = loadVarOverrides(opts.varFiles, opts.vars); err != nil {
+		log.Fatal(err)
+	}
+
+	if conf.policy, err = newPolicy(opts.onError, opts.answer, opts.answersFile); err != nil {
+		log.Fatal(err)
+	}
+
+	os.Setenv("HM_CONFIG", confFile)
+	taskNames := taskArgs
+	if len(taskNames) == 0 && (len(opts.tags) == 0 || isFlagSet("task", flag.CommandLine, flagSets[command])) {
+		taskNames = splitList(opts.taskName)
+	}
+	if tags := splitList(opts.tags); len(tags) > 0 {
+		tagged := selectTagged(tags, conf)
+		if len(tagged) == 0 {
+			log.Fatalf("no tasks found with tags: %s", strings.Join(tags, ", "))
+		}
+		for _, tn := range tagged {
+			if !containsString(taskNames, tn) {
+				taskNames = append(taskNames, tn)
+			}
+		}
+	}
+
+	os.Setenv("HM_TASK", strings.Join(taskNames, ","))
+	os.Setenv("HM_SRC", conf.srcDir)
+	os.Setenv("HM_DEST", conf.dstDir)
+	os.Setenv("HM_VARIANT", conf.variant)
+
+	switch command {
+	case "validate":
+		if err := conf.validate(nil); err != nil {
+			log.Fatal(err)
+		}
+		if conf.flags&flagVerbose != 0 {
+			log.Printf("configuration is valid")
+		}
+		return
+	case "config", "list", "restore", "vars":
+		err = conf.validate([]string{})
+	default:
+		err = conf.validate(taskNames)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if command != "config" && command != "list" && command != "restore" && command != "vars" {
+		if conf.state, err = loadState(confFile); err != nil {
+			log.Fatal(err)
+		}
+		if err := conf.resolvePrompts(conf.Vars, "", ""); err != nil {
+			log.Fatal(err)
+		}
+		for _, envFile := range opts.envFiles {
+			if err := loadEnvFile(envFile, "", conf); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if conf.graph, err = buildGraph(taskNames, conf); err != nil {
+			log.Fatal(err)
+		}
+		skipped := append(splitList(opts.skip), conf.graph.tagged(splitList(opts.skipTags), conf)...)
+		if err := conf.graph.skip(skipped, opts.skipDeps, conf); err != nil {
+			log.Fatal(err)
+		}
+		if conf.flags&flagVerbose != 0 {
+			log.Printf("resolved task order: %s", strings.Join(conf.graph.tasks(), ", "))
+		}
+	}
+
+	switch command {
+	case "apply", "plan", "unlink":
+		for _, root := range conf.graph.roots {
+			if err = processTask(root, conf); err != nil && err != errTaskFailed {
+				break
+			}
+		}
+		if err == nil && len(conf.failures) == 0 && conf.flags&flagPrune != 0 {
+			err = pruneTasks(conf)
+		}
+
+		if conf.flags&flagDryRun == 0 {
+			if err := conf.state.save(); err != nil {
+				log.Print(err)
+			}
+		}
+		if len(conf.failures) > 0 {
+			reportFailures(conf)
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "config":
+		showFormat := opts.format
+		if len(showFormat) == 0 {
+			showFormat = strings.TrimPrefix(filepath.Ext(confFile), ".")
+		}
+
+		bytes, err := marshalConfig(conf, showFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		os.Stdout.Write(bytes)
+	case "graph":
+		if err := printGraph(opts.format, opts.annotate, conf); err != nil {
+			log.Fatal(err)
+		}
+	case "list":
+		if err := printList(opts.format, opts.all, conf); err != nil {
+			log.Fatal(err)
+		}
+	case "restore":
+		if conf.state, err = loadState(confFile); err != nil {
+			log.Fatal(err)
+		}
+
+		err := restoreBackups(conf)
+		if conf.flags&flagDryRun == 0 {
+			if err := conf.state.save(); err != nil {
+				log.Print(err)
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "vars":
+		if conf.state, err = loadState(confFile); err != nil {
+			log.Fatal(err)
+		}
+
+		if varsAction == "list" {
+			err = printAnswers(opts.format, varNames, conf)
+		} else {
+			err = resetAnswers(varNames, conf)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		synced, err := statusTasks(conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !synced {
+			os.Exit(1)
+		}
+	}
 }
@@ -26,11 +26,10 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"strconv"
 )
 
-func parseLink(params []string) (srcPath, dstPath string, mode os.FileMode, err error) {
+func parseLink(params []string, conf *config) (srcPath, dstPath string, mode os.FileMode, err error) {
 	length := len(params)
 	if length < 1 || length > 3 {
 		err = fmt.Errorf("invalid link statement")
@@ -49,41 +48,39 @@ func parseLink(params []string) (srcPath, dstPath string, mode os.FileMode, err
 		mode = 0755
 	}
 
-	dstPath = os.ExpandEnv(params[0])
+	if dstPath, err = expand(params[0], conf); err != nil {
+		return
+	}
 	srcPath = dstPath
 	if length > 1 {
-		srcPath = os.ExpandEnv(params[1])
+		srcPath, err = expand(params[1], conf)
 	}
 
 	return
 }
 
 func processLink(params []string, conf *config) error {
-	srcPath, dstPath, mode, err := parseLink(params)
+	srcPath, dstPath, mode, err := parseLink(params, conf)
 	if err != nil {
 		return err
 	}
 
-	srcPathAbs := srcPath
-	if !filepath.IsAbs(srcPathAbs) {
-		srcPathAbs = filepath.Join(conf.srcDir, srcPath)
-	}
-
-	dstPathAbs := dstPath
-	if !filepath.IsAbs(dstPathAbs) {
-		dstPathAbs = filepath.Join(conf.dstDir, dstPath)
-	}
+	srcPathAbs, dstPathAbs := makeTaskPaths(srcPath, dstPath, conf)
 
 	if conf.flags&flagUnlink != flagUnlink {
 		if _, err := os.Stat(srcPathAbs); os.IsNotExist(err) {
-			return fmt.Errorf("source path %s does not exist in filesystem", srcPathAbs)
+			if conf.flags&flagDryRun != 0 {
+				plan("source path %s does not exist yet", srcPathAbs)
+			} else {
+				return fmt.Errorf("source path %s does not exist in filesystem", srcPathAbs)
+			}
 		}
 
-		if err := try(func() error { return createPath(dstPathAbs, conf.flags, mode) }); err != nil {
+		if err := try(func() error { return createPath(dstPathAbs, mode, conf) }, conf); err != nil {
 			return err
 		}
 
-		pathCleaned, err := cleanPath(dstPathAbs, conf.flags)
+		pathCleaned, err := cleanPath(dstPathAbs, conf)
 		if err != nil {
 			return err
 		}
@@ -95,16 +92,28 @@ func processLink(params []string, conf *config) error {
 			log.Printf("linking %s to %s", srcPathAbs, dstPathAbs)
 		}
 
-		return try(func() error {
-			return os.Symlink(srcPathAbs, dstPathAbs)
-		})
+		if conf.flags&flagDryRun != 0 {
+			plan("link %s to %s", srcPathAbs, dstPathAbs)
+			return nil
+		}
+
+		if err := try(func() error { return os.Symlink(srcPathAbs, dstPathAbs) }, conf); err != nil {
+			return err
+		}
+
+		conf.state.record(conf.task, recordLink, dstPathAbs, srcPathAbs, "")
+		return nil
 	} else {
 		stat, err := os.Lstat(dstPathAbs)
 		if os.IsNotExist(err) || stat.Mode()&os.ModeSymlink == 0 {
 			return nil
 		}
 
-		_, err = cleanPath(dstPathAbs, conf.flags)
-		return err
+		if _, err = cleanPath(dstPathAbs, conf); err != nil {
+			return err
+		}
+
+		conf.state.forget(recordLink, dstPathAbs)
+		return nil
 	}
 }
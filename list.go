@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type listEntry struct {
+	Name        string   `json:"name"`
+	Variant     string   `json:"variant,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Deps        []string `json:"deps,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Hidden      bool     `json:"hidden,omitempty"`
+}
+
+type listing struct {
+	Tasks  []listEntry `json:"tasks"`
+	Macros []listEntry `json:"macros"`
+}
+
+func newListEntry(name, description string, deps, tags []string, hidden bool) listEntry {
+	entry := listEntry{Name: name, Description: description, Deps: deps, Tags: tags, Hidden: hidden}
+	if nameParts := strings.Split(name, "__"); len(nameParts) > 1 {
+		entry.Name = strings.Join(nameParts[:len(nameParts)-1], "__")
+		entry.Variant = nameParts[len(nameParts)-1]
+	}
+
+	return entry
+}
+
+func sortListEntries(entries []listEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Variant < entries[j].Variant
+	})
+}
+
+func newListing(all bool, conf *config) *listing {
+	l := &listing{Tasks: []listEntry{}, Macros: []listEntry{}}
+
+	for tn, t := range conf.Tasks {
+		if all || !t.Hidden {
+			l.Tasks = append(l.Tasks, newListEntry(tn, t.Description, t.Deps, t.Tags, t.Hidden))
+		}
+	}
+
+	for mn, m := range conf.Macros {
+		if all || !m.Hidden {
+			l.Macros = append(l.Macros, newListEntry(mn, m.Description, m.Deps, nil, m.Hidden))
+		}
+	}
+
+	sortListEntries(l.Tasks)
+	sortListEntries(l.Macros)
+
+	return l
+}
+
+func writeListEntries(w *tabwriter.Writer, header string, entries []listEntry) {
+	fmt.Fprintf(w, "%s\tVARIANT\tDEPS\tTAGS\tDESCRIPTION\n", header)
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Name, entry.Variant, strings.Join(entry.Deps, ", "),
+			strings.Join(entry.Tags, ", "), entry.Description)
+	}
+}
+
+func printList(format string, all bool, conf *config) error {
+	l := newListing(all, conf)
+
+	switch format {
+	case "", "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		writeListEntries(w, "TASK", l.Tasks)
+		if len(l.Macros) > 0 {
+			fmt.Fprintln(w)
+			writeListEntries(w, "MACRO", l.Macros)
+		}
+		return w.Flush()
+	case "json":
+		bytes, err := json.MarshalIndent(l, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(bytes))
+		return nil
+	default:
+		return fmt.Errorf("unsupported list format: %s", format)
+	}
+}
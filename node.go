@@ -0,0 +1,340 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/naoina/toml"
+	"github.com/naoina/toml/ast"
+	"gopkg.in/yaml.v3"
+)
+
+type configNode struct {
+	line    int
+	col     int
+	comment string
+	keys    []string
+	fields  map[string]*configNode
+	items   []*configNode
+}
+
+type lineIndex []int
+
+func newLineIndex(data []rune) lineIndex {
+	index := lineIndex{0}
+	for i, r := range data {
+		if r == '\n' {
+			index = append(index, i+1)
+		}
+	}
+
+	return index
+}
+
+func (index lineIndex) position(offset int) (int, int) {
+	line := sort.SearchInts(index, offset+1) - 1
+	return line + 1, offset - index[line] + 1
+}
+
+func bytePosition(data []byte, offset int) (int, int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(data[:offset], '\n') + 1
+	return line, utf8.RuneCount(data[lineStart:offset]) + 1
+}
+
+func (n *configNode) field(key string, normalize func(string) string) *configNode {
+	if child, ok := n.fields[key]; ok {
+		return child
+	}
+
+	for _, k := range n.keys {
+		if normalize(k) == normalize(key) {
+			return n.fields[k]
+		}
+	}
+
+	return nil
+}
+
+func (n *configNode) locate(normalize func(string) string, path ...interface{}) (int, int) {
+	for _, elem := range path {
+		var child *configNode
+		switch elem := elem.(type) {
+		case string:
+			child = n.field(elem, normalize)
+		case int:
+			if elem < len(n.items) {
+				child = n.items[elem]
+			}
+		}
+
+		if child == nil {
+			break
+		}
+
+		n = child
+	}
+
+	return n.line, n.col
+}
+
+func (n *configNode) addField(key string, child *configNode) {
+	if n.fields == nil {
+		n.fields = make(map[string]*configNode)
+	}
+
+	n.keys = append(n.keys, key)
+	n.fields[key] = child
+}
+
+func (n *configNode) attachComments(data []byte) {
+	owners := make(map[int]*configNode)
+
+	var walk func(n *configNode)
+	walk = func(n *configNode) {
+		for _, key := range n.keys {
+			child := n.fields[key]
+			owners[child.line] = child
+			walk(child)
+		}
+		for _, item := range n.items {
+			walk(item)
+		}
+	}
+	walk(n)
+
+	lines := strings.Split(string(data), "\n")
+	for line, owner := range owners {
+		var comment []string
+		for i := line - 2; i >= 0 && i < len(lines); i-- {
+			text := strings.TrimSpace(lines[i])
+			if !strings.HasPrefix(text, "#") {
+				break
+			}
+			comment = append([]string{text}, comment...)
+		}
+
+		owner.comment = strings.Join(comment, "\n")
+	}
+}
+
+func parseJSONNode(data []byte) (*configNode, error) {
+	index := newLineIndex([]rune(string(data)))
+	position := func(offset int) (int, int) {
+		for offset < len(data) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+			offset++
+		}
+		return index.position(utf8.RuneCount(data[:offset]))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var parse func() (*configNode, error)
+	parse = func() (*configNode, error) {
+		node := new(configNode)
+		node.line, node.col = position(int(dec.InputOffset()))
+
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch token {
+		case json.Delim('{'):
+			for dec.More() {
+				line, col := position(int(dec.InputOffset()))
+
+				token, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+
+				child, err := parse()
+				if err != nil {
+					return nil, err
+				}
+
+				child.line, child.col = line, col
+				node.addField(token.(string), child)
+			}
+		case json.Delim('['):
+			for dec.More() {
+				child, err := parse()
+				if err != nil {
+					return nil, err
+				}
+
+				node.items = append(node.items, child)
+			}
+		default:
+			return node, nil
+		}
+
+		_, err = dec.Token()
+		return node, err
+	}
+
+	return parse()
+}
+
+func parseTOMLNode(data []byte) (*configNode, error) {
+	runes := []rune(string(data))
+	index := newLineIndex(runes)
+
+	lineStart := func(line int) int {
+		col := 1
+		for offset := index[line-1]; offset < len(runes) && (runes[offset] == ' ' || runes[offset] == '\t'); offset++ {
+			col++
+		}
+		return col
+	}
+
+	var convert func(value interface{}) *configNode
+	convert = func(value interface{}) *configNode {
+		node := new(configNode)
+
+		switch value := value.(type) {
+		case *ast.Table:
+			node.line = value.Line
+			for key, field := range value.Fields {
+				child := convert(field)
+				if node.fields == nil {
+					node.fields = make(map[string]*configNode)
+				}
+				node.fields[key] = child
+				node.keys = append(node.keys, key)
+				if node.line == 0 || child.line < node.line {
+					node.line = child.line
+				}
+			}
+
+			sort.Slice(node.keys, func(i, j int) bool {
+				a, b := node.fields[node.keys[i]], node.fields[node.keys[j]]
+				if a.line != b.line {
+					return a.line < b.line
+				}
+				return a.col < b.col
+			})
+		case []*ast.Table:
+			for _, table := range value {
+				node.items = append(node.items, convert(table))
+			}
+			if len(node.items) > 0 {
+				node.line = node.items[0].line
+			}
+		case *ast.KeyValue:
+			node = convert(value.Value)
+			node.line, _ = index.position(value.Value.Pos())
+			node.col = 0
+		case *ast.Array:
+			node.line, node.col = index.position(value.Pos())
+			for _, item := range value.Value {
+				node.items = append(node.items, convert(item))
+			}
+		case ast.Value:
+			node.line, node.col = index.position(value.Pos())
+		}
+
+		if node.line > 0 && node.col == 0 {
+			node.col = lineStart(node.line)
+		}
+
+		return node
+	}
+
+	table, err := toml.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	node := convert(table)
+	node.line, node.col = 1, 1
+	return node, nil
+}
+
+func parseYAMLNode(data []byte) (*configNode, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	var convert func(value *yaml.Node, line, col int) *configNode
+	convert = func(value *yaml.Node, line, col int) *configNode {
+		node := &configNode{line: line, col: col}
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+
+		switch value.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(value.Content); i += 2 {
+				key := value.Content[i]
+				node.addField(key.Value, convert(value.Content[i+1], key.Line, key.Column))
+			}
+		case yaml.SequenceNode:
+			for _, item := range value.Content {
+				node.items = append(node.items, convert(item, item.Line, item.Column))
+			}
+		}
+
+		return node
+	}
+
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return &configNode{line: 1, col: 1}, nil
+	}
+
+	return convert(root.Content[0], 1, 1), nil
+}
+
+func parseConfigNode(filename string, data []byte) (*configNode, error) {
+	switch filepath.Ext(filename) {
+	case ".json":
+		return parseJSONNode(data)
+	case ".toml", ".tml":
+		node, err := parseTOMLNode(data)
+		if err == nil {
+			node.attachComments(data)
+		}
+		return node, err
+	case ".yaml", ".yml":
+		node, err := parseYAMLNode(data)
+		if err == nil {
+			node.attachComments(data)
+		}
+		return node, err
+	default:
+		return nil, fmt.Errorf("unsupported configuration file format")
+	}
+}
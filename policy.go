@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	errorPrompt = "prompt"
+	errorAbort  = "abort"
+	errorRetry  = "retry"
+	errorSkip   = "skip"
+)
+
+const (
+	answerPrompt = "prompt"
+	answerYes    = "yes"
+	answerNo     = "no"
+)
+
+const (
+	promptClobber = "clobber"
+	promptCreate  = "create"
+)
+
+var promptTexts = map[string]string{
+	promptClobber: "clobber path",
+	promptCreate:  "force create path",
+}
+
+type policy struct {
+	onError string
+	retries int
+	answer  string
+	answers map[string]map[string]bool
+}
+
+func isInteractive() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+
+	return true
+}
+
+func newPolicy(onError, answer, answersFile string) (policy, error) {
+	var p policy
+
+	switch {
+	case onError == errorPrompt, onError == errorAbort, onError == errorSkip:
+		p.onError = onError
+	case strings.HasPrefix(onError, errorRetry+":"):
+		retries, err := strconv.Atoi(strings.TrimPrefix(onError, errorRetry+":"))
+		if err != nil || retries < 0 {
+			return p, fmt.Errorf("invalid retry count in error policy: %s", onError)
+		}
+		p.onError = errorRetry
+		p.retries = retries
+	default:
+		return p, fmt.Errorf("invalid error policy: %s", onError)
+	}
+
+	switch answer {
+	case answerPrompt, answerYes, answerNo:
+		p.answer = answer
+	default:
+		return p, fmt.Errorf("invalid prompt answer: %s", answer)
+	}
+
+	if !isInteractive() {
+		if p.onError == errorPrompt {
+			p.onError = errorAbort
+		}
+		if p.answer == answerPrompt {
+			p.answer = answerNo
+		}
+	}
+
+	if len(answersFile) > 0 {
+		if err := unmarshalFile(answersFile, &p.answers); err != nil {
+			return p, err
+		}
+
+		for kind := range p.answers {
+			if _, ok := promptTexts[kind]; !ok {
+				return p, fmt.Errorf("unknown prompt in answers file: %s", kind)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+func (p *policy) lookup(kind, loc, dstDir string) (bool, bool) {
+	patterns := make(map[string]bool)
+	for pattern, answer := range p.answers[kind] {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dstDir, pattern)
+		}
+		patterns[pattern] = answer
+	}
+
+	if answer, ok := patterns[loc]; ok {
+		return answer, true
+	}
+
+	var globs []string
+	for pattern := range patterns {
+		globs = append(globs, pattern)
+	}
+
+	sort.Slice(globs, func(i, j int) bool {
+		if len(globs[i]) != len(globs[j]) {
+			return len(globs[i]) > len(globs[j])
+		}
+		return globs[i] < globs[j]
+	})
+
+	for _, glob := range globs {
+		if matched, _ := filepath.Match(glob, loc); matched {
+			return patterns[glob], true
+		}
+	}
+
+	switch p.answer {
+	case answerYes:
+		return true, true
+	case answerNo:
+		return false, true
+	}
+
+	return false, false
+}
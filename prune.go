@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func pruneDirs(loc string, conf *config) error {
+	dirs := make(map[string]bool)
+	for _, r := range conf.state.records() {
+		if r.Kind == recordDir {
+			dirs[r.Path] = true
+		}
+	}
+
+	for dir := filepath.Dir(loc); dirs[dir]; loc, dir = dir, filepath.Dir(dir) {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil
+		}
+
+		for _, info := range infos {
+			if filepath.Join(dir, info.Name()) != loc {
+				return nil
+			}
+		}
+
+		if conf.flags&flagDryRun != 0 {
+			plan("remove directory: %s", dir)
+			continue
+		}
+
+		if conf.flags&flagVerbose != 0 {
+			log.Printf("removing directory: %s", dir)
+		}
+
+		if err := try(func() error { return os.Remove(dir) }, conf); err != nil {
+			return err
+		}
+
+		conf.state.forget(recordDir, dir)
+	}
+
+	return nil
+}
+
+func pruneLink(r stateRecord, conf *config) error {
+	info, err := os.Lstat(r.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if info != nil {
+		linked := info.Mode()&os.ModeSymlink != 0
+		if linked {
+			target, err := os.Readlink(r.Path)
+			if err != nil {
+				return err
+			}
+			linked = target == r.Source
+		}
+
+		if !linked {
+			if conf.flags&flagVerbose != 0 {
+				log.Printf("leaving path no longer linked by homemaker: %s", r.Path)
+			}
+			conf.state.forget(recordLink, r.Path)
+			return nil
+		}
+
+		if cleaned, err := cleanPath(r.Path, conf); err != nil || !cleaned {
+			return err
+		}
+	}
+
+	conf.state.forget(recordLink, r.Path)
+	return pruneDirs(r.Path, conf)
+}
+
+func pruneTasks(conf *config) error {
+	declared := make(map[string]bool)
+
+	visit := func(tn string, t *task) error {
+		for _, currLink := range t.Links {
+			srcPath, dstPath, _, err := parseLink(currLink, conf)
+			if err != nil {
+				return err
+			}
+
+			_, dstPathAbs := makeTaskPaths(srcPath, dstPath, conf)
+			declared[dstPathAbs] = true
+		}
+
+		return nil
+	}
+
+	if err := walkTasks(conf, visit); err != nil {
+		return err
+	}
+
+	var stale []stateRecord
+	for tn, st := range conf.state.config().Tasks {
+		if _, ok := conf.Tasks[tn]; ok && conf.graph.nodes[tn] == nil {
+			continue
+		}
+
+		for _, r := range st.Records {
+			if r.Kind == recordLink && !declared[r.Path] {
+				stale = append(stale, r)
+			}
+		}
+	}
+
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Path < stale[j].Path })
+
+	for _, r := range stale {
+		if conf.flags&flagVerbose != 0 {
+			log.Printf("pruning link: %s", r.Path)
+		}
+
+		if err := pruneLink(r, conf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+type jsonSchema map[string]interface{}
+
+func typeSchema(typ reflect.Type, definitions jsonSchema) jsonSchema {
+	switch typ.Kind() {
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.Slice:
+		schema := jsonSchema{"type": "array", "items": typeSchema(typ.Elem(), definitions)}
+		if typ.Elem().Kind() == reflect.Slice {
+			schema["items"].(jsonSchema)["minItems"] = 1
+		}
+		return schema
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": typeSchema(typ.Elem(), definitions)}
+	case reflect.Struct:
+		name := typ.Name()
+		if _, ok := definitions[name]; !ok {
+			definitions[name] = nil
+			definitions[name] = structSchema(typ, definitions)
+		}
+		return jsonSchema{"$ref": "#/definitions/" + name}
+	default:
+		return jsonSchema{}
+	}
+}
+
+func structSchema(typ reflect.Type, definitions jsonSchema) jsonSchema {
+	properties := make(jsonSchema)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		properties[name] = typeSchema(field.Type, definitions)
+	}
+
+	return jsonSchema{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+func configSchema() jsonSchema {
+	definitions := make(jsonSchema)
+	schema := structSchema(reflect.TypeOf(config{}), definitions)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "Homemaker configuration"
+	schema["definitions"] = definitions
+
+	return schema
+}
+
+func printSchema() error {
+	bytes, err := json.MarshalIndent(configSchema(), "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", bytes)
+	return err
+}
@@ -0,0 +1,275 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	recordLink     = "link"
+	recordTemplate = "template"
+	recordDir      = "dir"
+	recordClobber  = "clobber"
+	recordBackup   = "backup"
+)
+
+type stateRecord struct {
+	Kind   string    `json:"kind"`
+	Path   string    `json:"path"`
+	Source string    `json:"source,omitempty"`
+	Hash   string    `json:"hash,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+type stateTask struct {
+	Records []stateRecord `json:"records"`
+}
+
+type stateConfig struct {
+	Tasks   map[string]*stateTask  `json:"tasks"`
+	Answers map[string]interface{} `json:"answers,omitempty"`
+}
+
+type state struct {
+	Configs map[string]*stateConfig `json:"configs"`
+
+	filename  string
+	confFile  string
+	backupDir string
+}
+
+func stateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "homemaker")
+	}
+
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".local", "state", "homemaker")
+}
+
+func loadState(confFile string) (*state, error) {
+	s := &state{
+		Configs:  make(map[string]*stateConfig),
+		filename: filepath.Join(stateDir(), "state.json"),
+		confFile: confFile,
+	}
+
+	bytes, err := ioutil.ReadFile(s.filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bytes, s); err != nil {
+		return nil, err
+	}
+	if s.Configs == nil {
+		s.Configs = make(map[string]*stateConfig)
+	}
+
+	return s, nil
+}
+
+func (s *state) save() error {
+	if s == nil {
+		return nil
+	}
+
+	bytes, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.filename), 0755); err != nil {
+		return err
+	}
+
+	temp := s.filename + ".tmp"
+	if err := ioutil.WriteFile(temp, bytes, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, s.filename)
+}
+
+func (s *state) backups() (string, error) {
+	if len(s.backupDir) > 0 {
+		return s.backupDir, nil
+	}
+
+	parent := filepath.Join(filepath.Dir(s.filename), "backups")
+	if err := os.MkdirAll(parent, 0700); err != nil {
+		return "", err
+	}
+
+	dir, err := ioutil.TempDir(parent, time.Now().Format("20060102-150405")+"-")
+	if err != nil {
+		return "", err
+	}
+
+	s.backupDir = dir
+	return dir, nil
+}
+
+func (s *state) config() *stateConfig {
+	sc, ok := s.Configs[s.confFile]
+	if !ok {
+		sc = &stateConfig{Tasks: make(map[string]*stateTask)}
+		s.Configs[s.confFile] = sc
+	}
+
+	return sc
+}
+
+func (s *state) records() []stateRecord {
+	if s == nil {
+		return nil
+	}
+
+	var records []stateRecord
+	for _, st := range s.config().Tasks {
+		records = append(records, st.Records...)
+	}
+
+	return records
+}
+
+func (s *state) rendered(path, hash string) bool {
+	for _, r := range s.records() {
+		if r.Kind == recordTemplate && r.Path == path {
+			return len(hash) > 0 && r.Hash == hash
+		}
+	}
+
+	return false
+}
+
+func (s *state) record(taskName, kind, path, source, hash string) {
+	if s == nil {
+		return
+	}
+
+	if kind != recordBackup {
+		s.forget(kind, path)
+	}
+
+	sc := s.config()
+	st, ok := sc.Tasks[taskName]
+	if !ok {
+		st = &stateTask{}
+		sc.Tasks[taskName] = st
+	}
+
+	st.Records = append(st.Records, stateRecord{
+		Kind:   kind,
+		Path:   path,
+		Source: source,
+		Hash:   hash,
+		Time:   time.Now(),
+	})
+}
+
+func (s *state) forget(kind, path string) {
+	s.remove(func(r stateRecord) bool { return r.Kind == kind && r.Path == path })
+}
+
+func (s *state) forgetRecord(record stateRecord) {
+	s.remove(func(r stateRecord) bool {
+		return r.Kind == record.Kind && r.Path == record.Path && r.Source == record.Source
+	})
+}
+
+func (s *state) remove(match func(stateRecord) bool) {
+	if s == nil {
+		return
+	}
+
+	sc := s.config()
+	for taskName, st := range sc.Tasks {
+		records := st.Records[:0]
+		for _, r := range st.Records {
+			if !match(r) {
+				records = append(records, r)
+			}
+		}
+
+		if len(records) == 0 {
+			delete(sc.Tasks, taskName)
+		} else {
+			st.Records = records
+		}
+	}
+}
+
+func (s *state) answers() map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+
+	return s.config().Answers
+}
+
+func (s *state) answer(name string) (interface{}, bool) {
+	value, ok := s.answers()[name]
+	return value, ok
+}
+
+func (s *state) setAnswer(name string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	sc := s.config()
+	switch {
+	case value == nil:
+		delete(sc.Answers, name)
+	case sc.Answers == nil:
+		sc.Answers = map[string]interface{}{name: value}
+	default:
+		sc.Answers[name] = value
+	}
+}
+
+func hashFile(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
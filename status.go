@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+)
+
+const (
+	statusOk       = "ok"
+	statusMissing  = "missing"
+	statusForeign  = "foreign"
+	statusClobber  = "clobber"
+	statusModified = "modified"
+	statusNoSource = "nosource"
+	statusSkipped  = "skipped"
+	statusNoEval   = "noeval"
+)
+
+func reportStatus(state, dstPath, detail string, conf *config) bool {
+	if state == statusOk && conf.flags&flagVerbose == 0 {
+		return true
+	}
+
+	if len(detail) > 0 {
+		fmt.Printf("%-8s %s (%s)\n", state, dstPath, detail)
+	} else {
+		fmt.Printf("%-8s %s\n", state, dstPath)
+	}
+
+	return state == statusOk
+}
+
+func statusLink(params []string, conf *config) (bool, error) {
+	srcPath, dstPath, _, err := parseLink(params, conf)
+	if err != nil {
+		return false, err
+	}
+
+	srcPathAbs, dstPathAbs := makeTaskPaths(srcPath, dstPath, conf)
+
+	if _, err := os.Stat(srcPathAbs); os.IsNotExist(err) {
+		return reportStatus(statusNoSource, dstPathAbs, srcPathAbs, conf), nil
+	}
+
+	info, err := os.Lstat(dstPathAbs)
+	if os.IsNotExist(err) {
+		return reportStatus(statusMissing, dstPathAbs, "", conf), nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		return reportStatus(statusClobber, dstPathAbs, "", conf), nil
+	}
+
+	target, err := os.Readlink(dstPathAbs)
+	if err != nil {
+		return false, err
+	}
+	if target != srcPathAbs {
+		return reportStatus(statusForeign, dstPathAbs, target, conf), nil
+	}
+
+	return reportStatus(statusOk, dstPathAbs, "", conf), nil
+}
+
+func statusTemplate(params []string, conf *config) (bool, error) {
+	srcPath, dstPath, _, err := parseTemplate(params, conf)
+	if err != nil {
+		return false, err
+	}
+
+	srcPathAbs, dstPathAbs := makeTaskPaths(srcPath, dstPath, conf)
+
+	if _, err := os.Stat(srcPathAbs); os.IsNotExist(err) {
+		return reportStatus(statusNoSource, dstPathAbs, srcPathAbs, conf), nil
+	}
+
+	info, err := os.Lstat(dstPathAbs)
+	if os.IsNotExist(err) {
+		return reportStatus(statusMissing, dstPathAbs, "", conf), nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return reportStatus(statusClobber, dstPathAbs, "", conf), nil
+	}
+
+	t, err := template.ParseFiles(srcPathAbs)
+	if err != nil {
+		return false, err
+	}
+
+	var rendered bytes.Buffer
+	if err := t.Execute(&rendered, &context{conf.vars()}); err != nil {
+		return false, err
+	}
+
+	current, err := ioutil.ReadFile(dstPathAbs)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current, rendered.Bytes()) {
+		return reportStatus(statusModified, dstPathAbs, "", conf), nil
+	}
+
+	return reportStatus(statusOk, dstPathAbs, "", conf), nil
+}
+
+func statusEnv(env []string, conf *config) error {
+	if len(env) < 2 || !strings.HasPrefix(env[1], "!") {
+		return processEnv(env, conf)
+	}
+
+	name, err := expand(env[0], conf)
+	if err != nil {
+		return err
+	}
+
+	reportStatus(statusNoEval, "$"+name, "command not evaluated", conf)
+	return nil
+}
+
+func statusTasks(conf *config) (bool, error) {
+	synced := true
+
+	visit := func(tn string, t *task) error {
+		if t.skippable(conf) {
+			reportStatus(statusSkipped, "task "+tn, "conditions not met", conf)
+			return nil
+		}
+
+		defer scopeEnv(tn, t, conf)()
+
+		for _, envFile := range t.EnvFiles {
+			if err := loadEnvFile(envFile, conf.taskDir(tn), conf); err != nil {
+				return err
+			}
+		}
+
+		for _, currEnv := range t.Envs {
+			if err := statusEnv(currEnv, conf); err != nil {
+				return err
+			}
+		}
+
+		for _, currLink := range t.Links {
+			linkSynced, err := statusLink(currLink, conf)
+			if err != nil {
+				return err
+			}
+			synced = synced && linkSynced
+		}
+
+		for _, currTmpl := range t.Templates {
+			tmplSynced, err := statusTemplate(currTmpl, conf)
+			if err != nil {
+				return err
+			}
+			synced = synced && tmplSynced
+		}
+
+		return nil
+	}
+
+	if err := walkTasks(conf, visit); err != nil {
+		return false, err
+	}
+
+	return synced, nil
+}
@@ -23,59 +23,133 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"strings"
 )
 
+var errTaskFailed = errors.New("task failed")
+
 type task struct {
-	Deps      []string
-	Links     [][]string
-	CmdsPre   [][]string
-	Cmds      [][]string
-	CmdsPost  [][]string
-	Envs      [][]string
-	Accepts   [][]string
-	Rejects   [][]string
-	Templates [][]string
+	Description string                 `json:"description,omitempty" yaml:"description,omitempty" toml:",omitempty"`
+	Hidden      bool                   `json:"hidden,omitempty" yaml:"hidden,omitempty" toml:",omitempty"`
+	Tags        []string               `json:"tags,omitempty" yaml:"tags,omitempty" toml:",omitempty"`
+	Vars        map[string]interface{} `json:"vars,omitempty" yaml:"vars,omitempty" toml:",omitempty"`
+	Deps        []string               `json:"deps,omitempty" yaml:"deps,omitempty" toml:",omitempty"`
+	Links       [][]string             `json:"links,omitempty" yaml:"links,omitempty" toml:",omitempty"`
+	CmdsPre     [][]string             `json:"cmdspre,omitempty" yaml:"cmdspre,omitempty" toml:",omitempty"`
+	Cmds        [][]string             `json:"cmds,omitempty" yaml:"cmds,omitempty" toml:",omitempty"`
+	CmdsPost    [][]string             `json:"cmdspost,omitempty" yaml:"cmdspost,omitempty" toml:",omitempty"`
+	EnvFiles    []string               `json:"envfiles,omitempty" yaml:"envfiles,omitempty" toml:",omitempty"`
+	Envs        [][]string             `json:"envs,omitempty" yaml:"envs,omitempty" toml:",omitempty"`
+	EnvScope    string                 `json:"envscope,omitempty" yaml:"envscope,omitempty" toml:",omitempty"`
+	Exports     []string               `json:"exports,omitempty" yaml:"exports,omitempty" toml:",omitempty"`
+	Accepts     [][]string             `json:"accepts,omitempty" yaml:"accepts,omitempty" toml:",omitempty"`
+	Rejects     [][]string             `json:"rejects,omitempty" yaml:"rejects,omitempty" toml:",omitempty"`
+	Templates   [][]string             `json:"templates,omitempty" yaml:"templates,omitempty" toml:",omitempty"`
 }
 
-func (t *task) deps(conf *config) []string {
-	deps := t.Deps
+type entryError struct {
+	entry string
+	err   error
+}
 
-	if conf.flags&flagNoCmds == 0 {
-		for _, currCmd := range t.Cmds {
-			deps = append(deps, findCmdDeps(currCmd, conf)...)
+func (e *entryError) Error() string {
+	return e.err.Error()
+}
+
+type failure struct {
+	task  string
+	entry string
+	err   error
+}
+
+func describeEntry(kind string, params []string) string {
+	return fmt.Sprintf("%s [%s]", kind, strings.Join(params, " "))
+}
+
+func (t task) append(other task) task {
+	if len(other.Description) > 0 {
+		t.Description = other.Description
+	}
+
+	if len(other.EnvScope) > 0 {
+		t.EnvScope = other.EnvScope
+	}
+
+	t.Hidden = t.Hidden || other.Hidden
+	t.Tags = append(t.Tags, other.Tags...)
+	if len(other.Vars) > 0 {
+		t.Vars = mergeVars(mergeVars(nil, t.Vars), other.Vars)
+	}
+	t.Deps = append(t.Deps, other.Deps...)
+	t.Links = append(t.Links, other.Links...)
+	t.CmdsPre = append(t.CmdsPre, other.CmdsPre...)
+	t.Cmds = append(t.Cmds, other.Cmds...)
+	t.CmdsPost = append(t.CmdsPost, other.CmdsPost...)
+	t.EnvFiles = append(t.EnvFiles, other.EnvFiles...)
+	t.Envs = append(t.Envs, other.Envs...)
+	t.Exports = append(t.Exports, other.Exports...)
+	t.Accepts = append(t.Accepts, other.Accepts...)
+	t.Rejects = append(t.Rejects, other.Rejects...)
+	t.Templates = append(t.Templates, other.Templates...)
+
+	return t
+}
+
+func (t *task) tagged(tags []string) bool {
+	for _, tag := range t.Tags {
+		if containsString(tags, tag) {
+			return true
 		}
 	}
 
-	return deps
+	return false
 }
 
 func (t *task) process(conf *config) error {
-	for _, currTask := range t.deps(conf) {
-		currTask = os.ExpandEnv(currTask)
-		if err := processTask(currTask, conf); err != nil {
-			return err
+	var failedDeps []string
+	for _, currTask := range conf.graph.taskDeps(conf.task) {
+		if err := processTask(currTask, conf); err == errTaskFailed {
+			failedDeps = append(failedDeps, currTask)
+		} else if err != nil {
+			return &entryError{"dependency " + currTask, err}
+		}
+	}
+
+	if len(failedDeps) > 0 {
+		return &entryError{"dependencies", fmt.Errorf("skipped after failure of %s", strings.Join(failedDeps, ", "))}
+	}
+
+	if conf.flags&flagDryRun != 0 {
+		plan("process task: %s", conf.task)
+	}
+
+	defer scopeEnv(conf.task, t, conf)()
+
+	for _, envFile := range t.EnvFiles {
+		if err := loadEnvFile(envFile, conf.taskDir(conf.task), conf); err != nil {
+			return &entryError{describeEntry("envfile", []string{envFile}), err}
 		}
 	}
 
 	for _, currEnv := range t.Envs {
 		if err := processEnv(currEnv, conf); err != nil {
-			return err
+			return &entryError{describeEntry("env", currEnv), err}
 		}
 	}
 
 	if conf.flags&flagNoCmds == 0 {
 		for _, currCmd := range t.CmdsPre {
 			if err := processCmd(currCmd, true, conf); err != nil {
-				return err
+				return &entryError{describeEntry("command", currCmd), err}
 			}
 		}
 
 		for _, currCmd := range t.Cmds {
 			if err := processCmd(currCmd, true, conf); err != nil {
-				return err
+				return &entryError{describeEntry("command", currCmd), err}
 			}
 		}
 	}
@@ -83,7 +157,7 @@ func (t *task) process(conf *config) error {
 	if conf.flags&flagNoLinks == 0 {
 		for _, currLink := range t.Links {
 			if err := processLink(currLink, conf); err != nil {
-				return err
+				return &entryError{describeEntry("link", currLink), err}
 			}
 		}
 	}
@@ -91,7 +165,7 @@ func (t *task) process(conf *config) error {
 	if conf.flags&flagNoTemplates == 0 {
 		for _, currTmpl := range t.Templates {
 			if err := processTemplate(currTmpl, conf); err != nil {
-				return err
+				return &entryError{describeEntry("template", currTmpl), err}
 			}
 		}
 	}
@@ -99,7 +173,7 @@ func (t *task) process(conf *config) error {
 	if conf.flags&flagNoCmds == 0 {
 		for _, currCmd := range t.CmdsPost {
 			if err := processCmd(currCmd, true, conf); err != nil {
-				return err
+				return &entryError{describeEntry("command", currCmd), err}
 			}
 		}
 	}
@@ -108,6 +182,13 @@ func (t *task) process(conf *config) error {
 }
 
 func (t *task) skippable(conf *config) bool {
+	if conf.flags&flagDryRun != 0 {
+		if len(t.Accepts) > 0 || len(t.Rejects) > 0 {
+			plan("conditions for task not evaluated, assuming it runs")
+		}
+		return false
+	}
+
 	for _, currCnd := range t.Accepts {
 		if err := processCmd(currCnd, false, conf); err != nil {
 			return true
@@ -124,27 +205,59 @@ func (t *task) skippable(conf *config) bool {
 }
 
 func processTask(taskName string, conf *config) error {
-	for _, tn := range makeVariantNames(taskName, conf.variant) {
-		t, ok := conf.Tasks[tn]
-		if !ok {
-			continue
-		}
+	t, ok := conf.Tasks[taskName]
+	if !ok {
+		return fmt.Errorf("task or variant not found: %s", taskName)
+	}
 
-		if conf.handled[tn] || t.skippable(conf) {
-			if conf.flags&flagVerbose != 0 {
-				log.Printf("skipping task: %s", tn)
-			}
+	if conf.handled[taskName] && conf.failed[taskName] {
+		return errTaskFailed
+	}
 
-			return nil
+	if conf.handled[taskName] || t.skippable(conf) {
+		if conf.flags&flagVerbose != 0 {
+			log.Printf("skipping task: %s", taskName)
 		}
 
-		if conf.flags&flagVerbose != 0 {
-			log.Printf("processing task: %s", tn)
+		return nil
+	}
+
+	if conf.flags&flagVerbose != 0 {
+		log.Printf("processing task: %s", taskName)
+	}
+
+	conf.handled[taskName] = true
+
+	prevTask := conf.task
+	conf.task = taskName
+	defer func() { conf.task = prevTask }()
+
+	err := t.process(conf)
+	if err != nil && conf.flags&flagKeepGoing != 0 {
+		f := failure{task: taskName, err: err}
+		if ee, ok := err.(*entryError); ok {
+			f.entry, f.err = ee.entry, ee.err
 		}
 
-		conf.handled[tn] = true
-		return t.process(conf)
+		conf.failed[taskName] = true
+		conf.failures = append(conf.failures, f)
+		return errTaskFailed
 	}
 
-	return fmt.Errorf("task or variant not found: %s", taskName)
+	return err
+}
+
+func walkTasks(conf *config, visit func(string, *task) error) error {
+	prevTask := conf.task
+	defer func() { conf.task = prevTask }()
+
+	for _, tn := range conf.graph.tasks() {
+		t := conf.Tasks[tn]
+		conf.task = tn
+		if err := visit(tn, &t); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func capturePlan(t *testing.T, run func() error) []string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	err = run()
+	os.Stdout = stdout
+	w.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSpace(string(output)), "\n")
+}
+
+func TestPlanOrder(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.toml": `
+[tasks.default]
+deps = ["x", "y"]
+cmds = [["echo", "default"]]
+
+[tasks.x]
+deps = ["y"]
+cmds = [["echo", "x"]]
+
+[tasks.y]
+cmds = [["echo", "y"]]
+`,
+	})
+
+	conf, err := newConfig([]string{filepath.Join(dir, "config.toml")}, mergeError)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.srcDir, conf.dstDir = dir, dir
+	conf.flags = flagDryRun
+	if conf.graph, err = buildGraph([]string{"default"}, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := capturePlan(t, func() error { return processTask("default", conf) })
+	expected := []string{
+		"plan: process task: y",
+		"plan: execute command: echo y",
+		"plan: process task: x",
+		"plan: execute command: echo x",
+		"plan: process task: default",
+		"plan: execute command: echo default",
+	}
+
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected plan:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
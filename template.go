@@ -23,28 +23,30 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
 	"text/template"
 )
 
 type context struct {
+	vars map[string]interface{}
 }
 
 func (c *context) Env() map[string]string {
-	env := make(map[string]string)
-	for _, i := range os.Environ() {
-		sep := strings.Index(i, "=")
-		env[i[0:sep]] = i[sep+1:]
-	}
-	return env
+	return environ()
+}
+
+func (c *context) Vars() map[string]interface{} {
+	return c.vars
 }
 
-func parseTemplate(params []string) (srcPath, dstPath string, mode os.FileMode, err error) {
+func parseTemplate(params []string, conf *config) (srcPath, dstPath string, mode os.FileMode, err error) {
 	length := len(params)
 	if length < 1 || length > 3 {
 		err = fmt.Errorf("invalid template statement")
@@ -63,40 +65,38 @@ func parseTemplate(params []string) (srcPath, dstPath string, mode os.FileMode,
 		mode = 0755
 	}
 
-	dstPath = os.ExpandEnv(params[0])
+	if dstPath, err = expand(params[0], conf); err != nil {
+		return
+	}
 	srcPath = dstPath
 	if length > 1 {
-		srcPath = os.ExpandEnv(params[1])
+		srcPath, err = expand(params[1], conf)
 	}
 
 	return
 }
 
 func processTemplate(params []string, conf *config) (err error) {
-	srcPath, dstPath, mode, err := parseTemplate(params)
+	srcPath, dstPath, mode, err := parseTemplate(params, conf)
 	if err != nil {
 		return err
 	}
 
-	srcPathAbs := srcPath
-	if !filepath.IsAbs(srcPathAbs) {
-		srcPathAbs = filepath.Join(conf.srcDir, srcPath)
-	}
-
-	dstPathAbs := dstPath
-	if !filepath.IsAbs(dstPathAbs) {
-		dstPathAbs = filepath.Join(conf.dstDir, dstPath)
-	}
+	srcPathAbs, dstPathAbs := makeTaskPaths(srcPath, dstPath, conf)
 
 	if _, err = os.Stat(srcPathAbs); os.IsNotExist(err) {
-		return fmt.Errorf("source path %s does not exist in filesystem", srcPathAbs)
+		if conf.flags&flagDryRun != 0 {
+			plan("source path %s does not exist yet", srcPathAbs)
+		} else {
+			return fmt.Errorf("source path %s does not exist in filesystem", srcPathAbs)
+		}
 	}
 
-	if err = try(func() error { return createPath(dstPathAbs, conf.flags, mode) }); err != nil {
+	if err = try(func() error { return createPath(dstPathAbs, mode, conf) }, conf); err != nil {
 		return err
 	}
 
-	pathCleaned, err := cleanPath(dstPathAbs, conf.flags)
+	pathCleaned, err := cleanPath(dstPathAbs, conf)
 	if err != nil {
 		return err
 	}
@@ -108,20 +108,29 @@ func processTemplate(params []string, conf *config) (err error) {
 		log.Printf("process template %s to %s", srcPathAbs, dstPathAbs)
 	}
 
+	if conf.flags&flagDryRun != 0 {
+		plan("render template %s to %s", srcPathAbs, dstPathAbs)
+		return nil
+	}
+
 	t, err := template.ParseFiles(srcPathAbs)
 	if err != nil {
 		return err
 	}
 
-	f, err := os.Create(dstPathAbs)
-	if err != nil {
+	var rendered bytes.Buffer
+	render := func() error {
+		rendered.Reset()
+		if err := t.Execute(&rendered, &context{conf.vars()}); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dstPathAbs, rendered.Bytes(), 0666)
+	}
+	if err = try(render, conf); err != nil {
 		return err
 	}
-	defer func() {
-		err = f.Close()
-	}()
 
-	return try(func() error {
-		return t.Execute(f, &context{})
-	})
+	hash := sha256.Sum256(rendered.Bytes())
+	conf.state.record(conf.task, recordTemplate, dstPathAbs, srcPathAbs, hex.EncodeToString(hash[:]))
+	return nil
 }
@@ -23,20 +23,16 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func appendExpEnv(dst, src []string) []string {
-	for _, value := range src {
-		dst = append(dst, os.ExpandEnv(value))
-	}
-
-	return dst
-}
+var stdin = bufio.NewReader(os.Stdin)
 
 func makeAbsPath(path string) string {
 	path, err := filepath.Abs(path)
@@ -47,28 +43,62 @@ func makeAbsPath(path string) string {
 	return path
 }
 
-func cleanPath(loc string, flags int) (bool, error) {
+func makeTaskPaths(srcPath, dstPath string, conf *config) (string, string) {
+	if !filepath.IsAbs(srcPath) {
+		srcPath = filepath.Join(conf.srcDir, srcPath)
+	}
+
+	if !filepath.IsAbs(dstPath) {
+		dstPath = filepath.Join(conf.dstDir, dstPath)
+	}
+
+	return srcPath, dstPath
+}
+
+func cleanPath(loc string, conf *config) (bool, error) {
 	if info, _ := os.Lstat(loc); info != nil {
+		if conf.flags&flagDryRun != 0 {
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				plan("remove symlink: %s", loc)
+			case conf.flags&flagClobber != 0 && conf.flags&flagBackup != 0:
+				plan("back up path: %s", loc)
+			case conf.flags&flagClobber != 0:
+				plan("clobber path: %s", loc)
+			default:
+				plan("prompt to clobber path: %s", loc)
+			}
+			return true, nil
+		}
+
 		if info.Mode()&os.ModeSymlink == 0 {
 			shouldContinue := false
-			if flags&flagClobber == 0 {
-				shouldContinue = prompt("clobber path", loc)
+			if conf.flags&flagClobber == 0 {
+				shouldContinue = prompt(promptClobber, loc, conf)
 			}
-			if flags&flagClobber != 0 || shouldContinue {
-				if flags&flagVerbose != 0 {
+			if conf.flags&flagClobber != 0 || shouldContinue {
+				if conf.flags&flagVerbose != 0 {
 					log.Printf("clobbering path: %s", loc)
 				}
-				if err := try(func() error { return os.RemoveAll(loc) }) ; err != nil {
-					return false, err
+				hash := hashFile(loc)
+				if conf.flags&flagBackup != 0 && !conf.state.rendered(loc, hash) {
+					if err := try(func() error { return backupPath(loc, hash, conf) }, conf); err != nil {
+						return false, err
+					}
+				} else {
+					if err := try(func() error { return os.RemoveAll(loc) }, conf); err != nil {
+						return false, err
+					}
+					conf.state.record(conf.task, recordClobber, loc, "", hash)
 				}
 			} else {
 				return false, nil
 			}
 		} else {
-			if flags&flagVerbose != 0 {
+			if conf.flags&flagVerbose != 0 {
 				log.Printf("removing symlink: %s", loc)
 			}
-			if err := try(func() error { return os.Remove(loc) }); err != nil {
+			if err := try(func() error { return os.Remove(loc) }, conf); err != nil {
 				return false, err
 			}
 		}
@@ -76,17 +106,36 @@ func cleanPath(loc string, flags int) (bool, error) {
 	return true, nil
 }
 
-func createPath(loc string, flags int, mode os.FileMode) error {
+func createPath(loc string, mode os.FileMode, conf *config) error {
 	parentDir := filepath.Dir(loc)
 
 	if _, err := os.Stat(parentDir); os.IsNotExist(err) {
-		if flags&flagForce != 0 || prompt("force create path", parentDir) {
-			if flags&flagVerbose != 0 {
+		if conf.flags&flagDryRun != 0 {
+			if conf.flags&flagForce != 0 {
+				plan("create directory: %s", parentDir)
+			} else {
+				plan("prompt to create directory: %s", parentDir)
+			}
+			return nil
+		}
+
+		if conf.flags&flagForce != 0 || prompt(promptCreate, parentDir, conf) {
+			if conf.flags&flagVerbose != 0 {
 				log.Printf("force creating path: %s", parentDir)
 			}
+			var created []string
+			for dir := parentDir; ; dir = filepath.Dir(dir) {
+				if _, err := os.Stat(dir); !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+					break
+				}
+				created = append(created, dir)
+			}
 			if err := os.MkdirAll(parentDir, mode); err != nil {
 				return err
 			}
+			for _, dir := range created {
+				conf.state.record(conf.task, recordDir, dir, "", "")
+			}
 		}
 	}
 
@@ -107,12 +156,47 @@ func makeVariantNames(name, variant string) []string {
 	return names
 }
 
-func prompt(prompts ...string) bool {
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func plan(format string, args ...interface{}) {
+	fmt.Printf("plan: %s\n", fmt.Sprintf(format, args...))
+}
+
+func prompt(kind, loc string, conf *config) bool {
+	if answer, ok := conf.policy.lookup(kind, loc, conf.dstDir); ok {
+		if conf.flags&flagVerbose != 0 {
+			log.Printf("answering %s prompt for %s: %t", kind, loc, answer)
+		}
+		return answer
+	}
+
 	for {
-		fmt.Printf("%s: [y]es, [n]o? ", strings.Join(prompts, " "))
+		fmt.Printf("%s %s: [y]es, [n]o? ", promptTexts[kind], loc)
 
 		var ans string
-		fmt.Scanln(&ans)
+		if _, err := fmt.Fscanln(stdin, &ans); err == io.EOF {
+			fmt.Println()
+			return false
+		}
 
 		switch strings.ToLower(ans) {
 		case "y":
@@ -123,19 +207,47 @@ func prompt(prompts ...string) bool {
 	}
 }
 
-func try(task func() error) error {
-	for {
+func readLine() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil {
+		return line, err
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+func try(task func() error, conf *config) error {
+	for attempt := 1; ; attempt++ {
 		err := task()
 		if err == nil {
 			return nil
 		}
 
+		switch conf.policy.onError {
+		case errorAbort:
+			return err
+		case errorSkip:
+			log.Printf("skipping after error: %s", err)
+			return nil
+		case errorRetry:
+			if attempt > conf.policy.retries {
+				return err
+			}
+			if conf.flags&flagVerbose != 0 {
+				log.Printf("retrying after error (%d of %d): %s", attempt, conf.policy.retries, err)
+			}
+			continue
+		}
+
 	loop:
 		for {
 			fmt.Printf("%s: [a]bort, [r]etry, [c]ancel? ", err)
 
 			var ans string
-			fmt.Scanln(&ans)
+			if _, scanErr := fmt.Fscanln(stdin, &ans); scanErr == io.EOF {
+				fmt.Println()
+				return err
+			}
 
 			switch strings.ToLower(ans) {
 			case "a":
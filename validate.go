@@ -0,0 +1,522 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type problem struct {
+	file string
+	line int
+	col  int
+	msg  string
+	warn bool
+}
+
+func (p problem) String() string {
+	msg := p.msg
+	if p.warn {
+		msg = "warning: " + msg
+	}
+	if len(p.file) == 0 {
+		return msg
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s", p.file, p.line, p.col, msg)
+}
+
+type reference struct {
+	name  string
+	macro bool
+	path  []interface{}
+}
+
+func keyNormalizer(filename string) func(string) string {
+	switch filepath.Ext(filename) {
+	case ".json":
+		return strings.ToLower
+	case ".toml", ".tml":
+		return func(key string) string { return strings.ToLower(strings.Replace(key, "_", "", -1)) }
+	default:
+		return func(key string) string { return key }
+	}
+}
+
+func schemaKeys(typ reflect.Type) []string {
+	var keys []string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+
+		keys = append(keys, strings.Split(field.Tag.Get("json"), ",")[0])
+	}
+
+	return keys
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev = curr
+	}
+
+	return prev[len(b)]
+}
+
+func (conf *config) report(filename string, line, col int, format string, args ...interface{}) {
+	conf.problems = append(conf.problems, problem{filename, line, col, fmt.Sprintf(format, args...), false})
+}
+
+func (conf *config) warn(filename string, line, col int, format string, args ...interface{}) {
+	conf.problems = append(conf.problems, problem{filename, line, col, fmt.Sprintf(format, args...), true})
+}
+
+func (conf *config) checkKeys(filename string, node *configNode, typ reflect.Type, where string) {
+	if node == nil {
+		return
+	}
+
+	normalize := keyNormalizer(filename)
+	known := schemaKeys(typ)
+
+	for _, key := range node.keys {
+		found, suggestion := false, ""
+		for _, k := range known {
+			if normalize(key) == normalize(k) {
+				found = true
+				break
+			}
+			if editDistance(strings.ToLower(key), k) <= 2 {
+				suggestion = k
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		msg := fmt.Sprintf("unknown key %q", key)
+		if len(where) > 0 {
+			msg += " in " + where
+		}
+		if len(suggestion) > 0 {
+			msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+
+		child := node.fields[key]
+		conf.warn(filename, child.line, child.col, "%s", msg)
+	}
+}
+
+func (conf *config) checkStructure(filename string, node *configNode) {
+	normalize := keyNormalizer(filename)
+	conf.checkKeys(filename, node, reflect.TypeOf(config{}), "")
+
+	if tasks := node.field("tasks", normalize); tasks != nil {
+		for _, tn := range tasks.keys {
+			conf.checkKeys(filename, tasks.fields[tn], reflect.TypeOf(task{}), "task "+tn)
+		}
+	}
+
+	if macros := node.field("macros", normalize); macros != nil {
+		for _, mn := range macros.keys {
+			conf.checkKeys(filename, macros.fields[mn], reflect.TypeOf(macro{}), "macro "+mn)
+		}
+	}
+}
+
+func checkMode(mode string) string {
+	parsed, err := strconv.ParseUint(mode, 0, 64)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("invalid mode %q", mode)
+	case !strings.HasPrefix(mode, "0"):
+		return fmt.Sprintf("mode %q is not in octal notation (did you mean \"0%s\"?)", mode, mode)
+	case parsed > 07777:
+		return fmt.Sprintf("mode %q is out of range", mode)
+	default:
+		return ""
+	}
+}
+
+func checkEnvScope(scope string) string {
+	switch scope {
+	case "", envScopeGlobal, envScopeTask:
+		return ""
+	default:
+		return fmt.Sprintf("invalid environment scope %q (expected %q or %q)", scope, envScopeGlobal, envScopeTask)
+	}
+}
+
+func (conf *config) checkPrompts(filename string, node *configNode, vars map[string]interface{}, path []interface{}, prefix string) {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		varPath := append(append([]interface{}(nil), path...), name)
+		fields, _ := vars[name].(map[string]interface{})
+		p, ok := parsePrompt(fields)
+		if !ok {
+			if fields != nil {
+				conf.checkPrompts(filename, node, fields, varPath, prefix+name+".")
+			}
+			continue
+		}
+
+		line, col := node.locate(keyNormalizer(filename), varPath...)
+		if _, ok := fields["choices"].([]interface{}); !ok && fields["choices"] != nil {
+			conf.report(filename, line, col, "variable %s: prompt choices must be a list", prefix+name)
+		}
+		if _, err := regexp.Compile(p.validate); err != nil {
+			conf.report(filename, line, col, "variable %s: invalid validation expression: %s", prefix+name, err)
+		} else if p.value != nil {
+			if err := p.check(p.value); err != nil {
+				conf.report(filename, line, col, "variable %s: invalid default: %s", prefix+name, err)
+			}
+		}
+	}
+}
+
+func (conf *config) checkEntries(filename string, node *configNode, fileConf *config) {
+	normalize := keyNormalizer(filename)
+
+	conf.checkPrompts(filename, node, fileConf.Vars, []interface{}{"vars"}, "")
+	if msg := checkEnvScope(fileConf.EnvScope); len(msg) > 0 {
+		line, col := node.locate(normalize, "envscope")
+		conf.report(filename, line, col, "%s", msg)
+	}
+
+	var names []string
+	for tn := range fileConf.Tasks {
+		names = append(names, tn)
+	}
+	sort.Strings(names)
+
+	for _, tn := range names {
+		t := fileConf.Tasks[tn]
+		at := func(path ...interface{}) (int, int) {
+			return node.locate(normalize, append([]interface{}{"tasks", tn}, path...)...)
+		}
+
+		for key, entries := range map[string][][]string{"links": t.Links, "templates": t.Templates} {
+			kind := strings.TrimSuffix(key, "s")
+			for i, entry := range entries {
+				line, col := at(key, i)
+				if len(entry) < 1 || len(entry) > 3 {
+					conf.report(filename, line, col, "task %s: %s statement must have 1 to 3 elements, found %d",
+						tn, kind, len(entry))
+					continue
+				}
+
+				if len(entry) > 2 {
+					if msg := checkMode(entry[2]); len(msg) > 0 {
+						line, col = at(key, i, 2)
+						conf.report(filename, line, col, "task %s: %s", tn, msg)
+					}
+				}
+			}
+		}
+
+		for i, envFile := range t.EnvFiles {
+			if len(strings.TrimPrefix(envFile, "?")) == 0 {
+				line, col := at("envfiles", i)
+				conf.report(filename, line, col, "task %s: empty environment file name", tn)
+			}
+		}
+
+		for i, entry := range t.Envs {
+			if len(entry) == 0 {
+				line, col := at("envs", i)
+				conf.report(filename, line, col, "task %s: environment statement must have at least 1 element", tn)
+			}
+		}
+
+		for key, entries := range map[string][][]string{
+			"cmdspre": t.CmdsPre, "cmds": t.Cmds, "cmdspost": t.CmdsPost, "accepts": t.Accepts, "rejects": t.Rejects,
+		} {
+			for i, entry := range entries {
+				if len(entry) == 0 {
+					line, col := at(key, i)
+					conf.report(filename, line, col, "task %s: command statement must have at least 1 element", tn)
+				}
+			}
+		}
+
+		for i, dep := range t.Deps {
+			if len(dep) == 0 {
+				line, col := at("deps", i)
+				conf.report(filename, line, col, "task %s: empty dependency name", tn)
+			}
+		}
+
+		if msg := checkEnvScope(t.EnvScope); len(msg) > 0 {
+			line, col := at("envscope")
+			conf.report(filename, line, col, "task %s: %s", tn, msg)
+		}
+
+		for i, name := range t.Exports {
+			if len(name) == 0 || strings.Contains(name, "=") {
+				line, col := at("exports", i)
+				conf.report(filename, line, col, "task %s: invalid exported variable name %q", tn, name)
+			}
+		}
+
+		conf.checkPrompts(filename, node, t.Vars, []interface{}{"tasks", tn, "vars"}, "")
+	}
+}
+
+func taskReferences(t task) []reference {
+	var refs []reference
+	for i, dep := range t.Deps {
+		refs = append(refs, reference{dep, false, []interface{}{"deps", i}})
+	}
+
+	for _, list := range []struct {
+		key     string
+		entries [][]string
+	}{
+		{"cmdspre", t.CmdsPre}, {"cmds", t.Cmds}, {"cmdspost", t.CmdsPost},
+		{"accepts", t.Accepts}, {"rejects", t.Rejects},
+	} {
+		for i, entry := range list.entries {
+			if len(entry) == 0 {
+				continue
+			}
+
+			if name := entry[0]; strings.HasPrefix(name, "@") {
+				refs = append(refs, reference{strings.TrimPrefix(name, "@"), true, []interface{}{list.key, i, 0}})
+			}
+		}
+	}
+
+	return refs
+}
+
+func macroReferences(m macro) []reference {
+	var refs []reference
+	for i, dep := range m.Deps {
+		refs = append(refs, reference{dep, false, []interface{}{"deps", i}})
+	}
+
+	return refs
+}
+
+func variantOf(name, variant string) string {
+	if nameParts := strings.Split(name, "__"); len(nameParts) > 1 {
+		return nameParts[len(nameParts)-1]
+	}
+
+	return variant
+}
+
+func (conf *config) resolveReference(ref reference, variant string) string {
+	for _, name := range makeVariantNames(ref.name, variant) {
+		if ref.macro {
+			if _, ok := conf.Macros[name]; ok {
+				return "@" + name
+			}
+		} else if _, ok := conf.Tasks[name]; ok {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func (conf *config) locateReference(origin, section, name string, ref reference) (int, int) {
+	if node := conf.nodes[origin]; node != nil {
+		return node.locate(keyNormalizer(origin), append([]interface{}{section, name}, ref.path...)...)
+	}
+
+	return 0, 0
+}
+
+func (conf *config) isVar(varName, name, section string) bool {
+	for _, vars := range []map[string]interface{}{conf.Vars, conf.overrides} {
+		if _, ok := lookupVar(vars, varName); ok {
+			return true
+		}
+	}
+	if section == "tasks" {
+		_, ok := lookupVar(conf.Tasks[name].Vars, varName)
+		return ok
+	}
+
+	return false
+}
+
+func (conf *config) checkReferences(taskNames []string) {
+	type item struct {
+		key     string
+		variant string
+	}
+
+	var queue []item
+	visited := make(map[string]bool)
+
+	envSetters := make(map[string]string)
+	for tn, t := range conf.Tasks {
+		for _, env := range t.Envs {
+			if len(env) == 0 {
+				continue
+			}
+			if prev, ok := envSetters[env[0]]; !ok || tn < prev {
+				envSetters[env[0]] = tn
+			}
+		}
+	}
+
+	if taskNames == nil {
+		for tn := range conf.Tasks {
+			queue = append(queue, item{tn, variantOf(tn, conf.variant)})
+		}
+		for mn := range conf.Macros {
+			queue = append(queue, item{"@" + mn, variantOf(mn, conf.variant)})
+		}
+		sort.Slice(queue, func(i, j int) bool { return queue[i].key < queue[j].key })
+	} else {
+		for _, taskName := range taskNames {
+			tn, _ := resolveTask(taskName, conf)
+			if len(tn) == 0 {
+				conf.report("", 0, 0, "task or variant not found: %s", taskName)
+				continue
+			}
+			queue = append(queue, item{tn, conf.variant})
+		}
+	}
+
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		if visited[curr.key] {
+			continue
+		}
+		visited[curr.key] = true
+
+		var refs []reference
+		origin, section, name := "", "tasks", curr.key
+		if strings.HasPrefix(curr.key, "@") {
+			section, name = "macros", strings.TrimPrefix(curr.key, "@")
+			refs = macroReferences(conf.Macros[name])
+		} else {
+			refs = taskReferences(conf.Tasks[name])
+		}
+		origin = conf.origins[section+"."+name]
+
+		for _, ref := range refs {
+			if strings.Contains(ref.name, "$") {
+				for _, envName := range referencedNames(ref.name) {
+					if setter, ok := envSetters[envName]; ok && !conf.isVar(envName, name, section) {
+						line, col := conf.locateReference(origin, section, name, ref)
+						conf.report(origin, line, col, "%s %s: %s refers to %s, which is only set by the envs of task %s "+
+							"(dependencies are resolved before any task runs)", strings.TrimSuffix(section, "s"), name,
+							ref.name, envName, setter)
+					}
+				}
+				continue
+			}
+
+			key := conf.resolveReference(ref, curr.variant)
+			if len(key) > 0 {
+				if taskNames != nil {
+					queue = append(queue, item{key, curr.variant})
+				}
+				continue
+			}
+
+			line, col := conf.locateReference(origin, section, name, ref)
+			kind := "task"
+			if ref.macro {
+				kind, ref.name = "macro", "@"+ref.name
+			}
+			conf.report(origin, line, col, "%s %s: %s or variant not found: %s",
+				strings.TrimSuffix(section, "s"), name, kind, ref.name)
+		}
+	}
+}
+
+func (conf *config) validate(taskNames []string) error {
+	conf.checkReferences(taskNames)
+	if len(conf.problems) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(conf.problems, func(i, j int) bool {
+		a, b := conf.problems[i], conf.problems[j]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		if a.line != b.line {
+			return a.line < b.line
+		}
+		return a.col < b.col
+	})
+
+	var count int
+	for _, p := range conf.problems {
+		p.warn = p.warn && conf.flags&flagAllowUnknown != 0
+		fmt.Fprintln(os.Stderr, p)
+		if !p.warn {
+			count++
+		}
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("configuration is invalid: %d problem(s) found", count)
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2015 Alex Yatskov <alex@foosoft.net>
+ * Author: Alex Yatskov <alex@foosoft.net>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func normalizeVar(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		vars := make(map[string]interface{})
+		for k, v := range value {
+			vars[fmt.Sprint(k)] = normalizeVar(v)
+		}
+		return vars
+	case map[string]interface{}:
+		for k, v := range value {
+			value[k] = normalizeVar(v)
+		}
+		return value
+	case []interface{}:
+		for i, v := range value {
+			value[i] = normalizeVar(v)
+		}
+		return value
+	default:
+		return value
+	}
+}
+
+func normalizeVars(vars map[string]interface{}) map[string]interface{} {
+	for name, value := range vars {
+		vars[name] = normalizeVar(value)
+	}
+
+	return vars
+}
+
+func mergeVars(dst map[string]interface{}, srcs ...map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{})
+	}
+
+	for _, src := range srcs {
+		for name, value := range src {
+			dstMap, dstOk := dst[name].(map[string]interface{})
+			srcMap, srcOk := value.(map[string]interface{})
+			if dstOk && srcOk {
+				value = mergeVars(mergeVars(nil, dstMap), srcMap)
+			}
+			dst[name] = value
+		}
+	}
+
+	return dst
+}
+
+func formatVar(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []interface{}:
+		var items []string
+		for _, item := range value {
+			items = append(items, formatVar(item))
+		}
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		bytes, _ := json.Marshal(value)
+		return string(bytes)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func parseValue(raw string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return raw
+	}
+
+	return normalizeVar(value)
+}
+
+func parseVar(assignment string) (string, interface{}, error) {
+	sep := strings.Index(assignment, "=")
+	if sep <= 0 {
+		return "", nil, fmt.Errorf("invalid variable assignment: %s", assignment)
+	}
+
+	return assignment[:sep], parseValue(assignment[sep+1:]), nil
+}
+
+func loadVarOverrides(varFiles, assignments []string) (map[string]interface{}, error) {
+	overrides := make(map[string]interface{})
+
+	for _, varFile := range varFiles {
+		vars := make(map[string]interface{})
+		if err := unmarshalFile(makeAbsPath(varFile), &vars); err != nil {
+			return nil, fmt.Errorf("%s: %w", varFile, err)
+		}
+		mergeVars(overrides, normalizeVars(vars))
+	}
+
+	for _, assignment := range assignments {
+		name, value, err := parseVar(assignment)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := strings.Split(name, ".")
+		for i := len(keys) - 1; i > 0; i-- {
+			value = map[string]interface{}{keys[i]: value}
+		}
+		mergeVars(overrides, map[string]interface{}{keys[0]: value})
+	}
+
+	return overrides, nil
+}
+
+func (conf *config) vars() map[string]interface{} {
+	var taskVars map[string]interface{}
+	if t, ok := conf.Tasks[conf.task]; ok {
+		taskVars = t.Vars
+	}
+
+	return mergeVars(nil, conf.Vars, taskVars, conf.overrides)
+}
+
+func lookupVar(vars map[string]interface{}, name string) (interface{}, bool) {
+	var value interface{} = vars
+	for _, key := range strings.Split(name, ".") {
+		switch container := value.(type) {
+		case map[string]interface{}:
+			item, ok := container[key]
+			if !ok {
+				return nil, false
+			}
+			value = item
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil, false
+			}
+			value = container[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}